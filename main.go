@@ -4,14 +4,21 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/Infisical/infisical-agent-injector/pkg/controller"
+	"github.com/Infisical/infisical-agent-injector/pkg/envexec"
 	"github.com/Infisical/infisical-agent-injector/pkg/injector"
+	"github.com/Infisical/infisical-agent-injector/pkg/logging"
+	"github.com/Infisical/infisical-agent-injector/pkg/secretsync"
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -42,12 +49,13 @@ func updateWebhookConfig(clientset *kubernetes.Clientset, cert []byte) {
 	// Base64 encode the cert
 	caBundle := base64.StdEncoding.EncodeToString(cert)
 
-	// Get the webhook configuration
+	// Get the webhook configurations
 	webhookConfigName := "infisical-agent-injector-cfg"
+	validatingWebhookConfigName := "infisical-agent-injector-validate-cfg"
 
 	// Retry loop for resiliency
 	for i := 0; i < 5; i++ {
-		log.Printf("Attempting to update webhook config (attempt %d)...", i+1)
+		slog.Info("Attempting to update webhook config", "attempt", i+1)
 
 		// Create a JSON patch
 		type patchValue struct {
@@ -68,7 +76,7 @@ func updateWebhookConfig(clientset *kubernetes.Clientset, cert []byte) {
 
 		patchBytes, err := json.Marshal(patch)
 		if err != nil {
-			log.Printf("Failed to marshal patch: %v, retrying...", err)
+			slog.Warn("Failed to marshal patch, retrying...", "error", err)
 			time.Sleep(2 * time.Second)
 			continue
 		}
@@ -82,19 +90,32 @@ func updateWebhookConfig(clientset *kubernetes.Clientset, cert []byte) {
 			metav1.PatchOptions{},
 		)
 		if err != nil {
-			log.Printf("Failed to patch webhook config: %v, retrying...", err)
+			slog.Warn("Failed to patch webhook config, retrying...", "error", err)
 			time.Sleep(2 * time.Second)
 			continue
 		}
 
-		log.Println("Successfully updated webhook configuration with CA bundle")
+		_, err = clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Patch(
+			context.Background(),
+			validatingWebhookConfigName,
+			types.JSONPatchType,
+			patchBytes,
+			metav1.PatchOptions{},
+		)
+		if err != nil {
+			slog.Warn("Failed to patch validating webhook config, retrying...", "error", err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
+
+		slog.Info("Successfully updated webhook configuration with CA bundle")
 		return
 	}
 
-	log.Println("Warning: Failed to update webhook configuration after multiple attempts")
+	slog.Warn("Failed to update webhook configuration after multiple attempts")
 }
 
-func getKubernetesClient() (*kubernetes.Clientset, error) {
+func getKubernetesConfig() (*rest.Config, error) {
 	// Try in-cluster config first
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -106,67 +127,129 @@ func getKubernetesClient() (*kubernetes.Clientset, error) {
 		}
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes clientset: %w", err)
-	}
+	return config, nil
+}
 
-	return clientset, nil
+// fatal logs the error and exits, slog has no equivalent of log.Fatalf
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
 
 func main() {
-	log.Println("Starting infisical-agent-injector...")
+	if err := logging.Setup(os.Getenv(logging.EnvLogLevel)); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+
+	// the injector image also runs in injected pods to sync rendered templates into kubernetes secrets
+	if len(os.Args) > 1 && os.Args[1] == "sync-secret" {
+		if err := secretsync.Run(os.Args[2:]); err != nil {
+			fatal("Failed to sync secrets", err)
+		}
+		return
+	}
 
-	kubeClient, err := getKubernetesClient()
+	// the injector binary is also copied into injected pods to load env templates before running the app entrypoint
+	if len(os.Args) > 1 && os.Args[1] == "env-exec" {
+		if err := envexec.Run(os.Args[2:]); err != nil {
+			fatal("Failed to execute command", err)
+		}
+		return
+	}
+
+	logLevel := flag.String("log-level", os.Getenv(logging.EnvLogLevel), "log level: debug, info, warn or error")
+	flag.Parse()
+
+	if err := logging.Setup(*logLevel); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+
+	slog.Info("Starting infisical-agent-injector...")
+
+	if err := util.LoadSettings(); err != nil {
+		fatal("Invalid injector settings", err)
+	}
+
+	kubeConfig, err := getKubernetesConfig()
+	if err != nil {
+		fatal("Failed to get kubernetes config", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
-		log.Fatalf("Failed to get kubernetes client: %v", err)
+		fatal("Failed to create kubernetes clientset", err)
+	}
+
+	// used for the InfisicalAgentConfig custom resource, which has no typed client
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		fatal("Failed to create kubernetes dynamic client", err)
 	}
 
 	// Generate self-signed cert
-	log.Printf("Generating self-signed certificate for namespace: %s", getNamespace())
+	slog.Info("Generating self-signed certificate", "namespace", getNamespace())
 	tlsCert, tlsKey, err := injector.GenerateSelfSignedCert(getNamespace())
 	if err != nil {
-		log.Fatalf("Failed to generate certificate: %v", err)
+		fatal("Failed to generate certificate", err)
+	}
+
+	// Cache the agent config resources and namespaces used to resolve the config of a pod
+	agentConfigCache, err := injector.NewAgentConfigCache(context.Background(), kubeClient, dynamicClient)
+	if err != nil {
+		fatal("Failed to create agent config cache", err)
 	}
 
 	// Setup HTTP handlers
 	handler := injector.Handler{
-		Client: kubeClient,
+		Client:           kubeClient,
+		DynamicClient:    dynamicClient,
+		AgentConfigCache: agentConfigCache,
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/mutate", handler.Handle)
+	mux.HandleFunc("/validate-config", handler.HandleValidateConfig)
 	mux.HandleFunc("/health/ready", handleReady)
 
 	// Write certs to temp directory
 	certPath := "/tmp/tls"
-	log.Printf("Creating directory: %s", certPath)
+	slog.Info("Creating directory", "path", certPath)
 	err = os.MkdirAll(certPath, 0755)
 	if err != nil {
-		log.Fatalf("Failed to create directory %s: %v", certPath, err)
+		fatal(fmt.Sprintf("Failed to create directory %s", certPath), err)
 	}
 
 	certFile := certPath + "/tls.crt"
 	keyFile := certPath + "/tls.key"
 
-	log.Printf("Writing cert to: %s", certFile)
+	slog.Info("Writing cert", "path", certFile)
 	err = os.WriteFile(certFile, tlsCert, 0644)
 	if err != nil {
-		log.Fatalf("Failed to write cert file: %v", err)
+		fatal("Failed to write cert file", err)
 	}
 
-	log.Printf("Writing key to: %s", keyFile)
+	slog.Info("Writing key", "path", keyFile)
 	err = os.WriteFile(keyFile, tlsKey, 0644)
 	if err != nil {
-		log.Fatalf("Failed to write key file: %v", err)
+		fatal("Failed to write key file", err)
 	}
 
 	// Update the webhook configuration with the CA bundle
 	go updateWebhookConfig(kubeClient, tlsCert)
 
+	// Watch agent config maps for changes to detect pods running an outdated config
+	configMapController, err := controller.NewConfigMapController(kubeClient)
+	if err != nil {
+		fatal("Failed to create config map controller", err)
+	}
+	go configMapController.Run(context.Background())
+
+	// Report how many pods use each InfisicalAgentConfig
+	go controller.NewAgentConfigStatusController(kubeClient, dynamicClient).Run(context.Background())
+
 	// Start the HTTPS server
-	log.Printf("Starting HTTPS server on port 8585...")
+	slog.Info("Starting HTTPS server", "port", 8585)
 	err = http.ListenAndServeTLS(":8585", certFile, keyFile, mux)
 	if err != nil {
-		log.Fatalf("Failed to start HTTPS server: %v", err)
+		fatal("Failed to start HTTPS server", err)
 	}
 }
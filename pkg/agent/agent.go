@@ -3,7 +3,8 @@ package agent
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"slices"
 	"strings"
 
@@ -12,6 +13,7 @@ import (
 	jsonpatch "github.com/evanphx/json-patch"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/utils/pointer"
 )
 
@@ -23,14 +25,20 @@ type Agent struct {
 	cachingEnabled            bool
 	agentImage                string
 	isWindows                 bool
+	logger                    *slog.Logger
+	warnings                  []string
 }
 
-func NewAgent(pod *corev1.Pod, configMap *util.ConfigMap) (*Agent, error) {
+func NewAgent(pod *corev1.Pod, configMap *util.ConfigMap, platform util.PodPlatform, logger *slog.Logger) (*Agent, error) {
 
 	if configMap == nil {
 		return nil, fmt.Errorf("config map is required")
 	}
 
+	if logger == nil {
+		logger = slog.Default()
+	}
+
 	injectMode := pod.Annotations[util.InjectModeAnnotation]
 	if injectMode == "" {
 		injectMode = util.InjectModeInit
@@ -47,8 +55,16 @@ func NewAgent(pod *corev1.Pod, configMap *util.ConfigMap) (*Agent, error) {
 		configMap.Infisical.Address = "https://app.infisical.com"
 	}
 
+	isWindows := platform.OS == util.OSWindows
+
+	annotationTemplates, err := templatesFromAnnotations(pod)
+	if err != nil {
+		return nil, err
+	}
+	configMap.Templates = append(configMap.Templates, annotationTemplates...)
+
 	if len(configMap.Templates) == 0 {
-		return nil, fmt.Errorf("no templates found in config map")
+		return nil, fmt.Errorf("no templates found in config map or %s annotations", util.AnnotationInjectTemplatePrefix+"<name>")
 	}
 	templateCount := len(configMap.Templates)
 	for i, template := range configMap.Templates {
@@ -62,13 +78,11 @@ func NewAgent(pod *corev1.Pod, configMap *util.ConfigMap) (*Agent, error) {
 	}
 
 	agentImage := pod.Annotations[util.AnnotationAgentImage]
-	isWindows := util.IsWindowsPod(pod)
 	if agentImage == "" {
-
-		if isWindows {
-			agentImage = util.DefaultWindowsContainerImage
-		} else {
-			agentImage = util.DefaultLinuxContainerImage
+		// rejects unsupported os and architecture combinations, instead of failing at image pull
+		agentImage, err = util.GetAgentImage(platform)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -80,6 +94,7 @@ func NewAgent(pod *corev1.Pod, configMap *util.ConfigMap) (*Agent, error) {
 		cachingEnabled:            cachingEnabled,
 		agentImage:                agentImage,
 		isWindows:                 isWindows,
+		logger:                    logger,
 	}, nil
 }
 
@@ -107,19 +122,18 @@ func (a *Agent) ContainerVolumeMounts(existingMounts []corev1.VolumeMount) []cor
 	for i, template := range a.configMap.Templates {
 
 		destinationPath := path.Dir(template.DestinationPath, a.isWindows)
-
-		var name string
-		if templateCount > 1 {
-			name = fmt.Sprintf("infisical-secrets-%d", i+1)
-		} else {
-			name = "infisical-secrets"
-		}
+		name := secretVolumeName(i, templateCount)
 
 		alreadyExists := false
 
 		for _, volumeMount := range existingMounts {
 			if volumeMount.MountPath == destinationPath {
 				alreadyExists = true
+
+				// the template is rendered into a volume of the app instead of the injected secret volume
+				if !a.isSecretVolume(volumeMount.Name) {
+					a.warn("template %s is rendered into existing volume %s mounted at %s", template.DestinationPath, volumeMount.Name, destinationPath)
+				}
 				break
 			}
 		}
@@ -134,11 +148,11 @@ func (a *Agent) ContainerVolumeMounts(existingMounts []corev1.VolumeMount) []cor
 		}
 
 		if alreadyExists {
-			log.Printf("volume mount %s already exists at %s. skipping creation.", name, destinationPath)
+			a.logger.Debug("Volume mount already exists, skipping creation", "volume", name, "mount_path", destinationPath)
 			continue
 		}
 
-		log.Printf("adding volume mount %s to %s", name, destinationPath)
+		a.logger.Debug("Adding volume mount", "volume", name, "mount_path", destinationPath)
 
 		volumeMounts = append(volumeMounts, corev1.VolumeMount{
 			Name:      name,
@@ -149,6 +163,33 @@ func (a *Agent) ContainerVolumeMounts(existingMounts []corev1.VolumeMount) []cor
 	return volumeMounts
 }
 
+func secretVolumeName(templateIndex int, templateCount int) string {
+	if templateCount > 1 {
+		return fmt.Sprintf("infisical-secrets-%d", templateIndex+1)
+	}
+	return "infisical-secrets"
+}
+
+// AppContainerVolumeMounts returns the volume mounts for the app containers.
+// templates that are only written to a kubernetes secret are not mounted.
+func (a *Agent) AppContainerVolumeMounts(existingMounts []corev1.VolumeMount) []corev1.VolumeMount {
+	volumeMounts := a.ContainerVolumeMounts(existingMounts)
+
+	templateCount := len(a.configMap.Templates)
+	for i, template := range a.configMap.Templates {
+		if template.KubernetesSecret == nil || !template.KubernetesSecret.Only {
+			continue
+		}
+
+		name := secretVolumeName(i, templateCount)
+		volumeMounts = slices.DeleteFunc(volumeMounts, func(mount corev1.VolumeMount) bool {
+			return mount.Name == name
+		})
+	}
+
+	return volumeMounts
+}
+
 func (a *Agent) ValidateConfigMap() error {
 	if err := util.ValidateInjectMode(a.injectMode); err != nil {
 		return err
@@ -183,6 +224,12 @@ func (a *Agent) ValidateConfigMap() error {
 		}
 	}
 
+	if err := a.validateTLSConfig(); err != nil {
+		return err
+	}
+
+	a.collectConfigWarnings()
+
 	delimiter := "/"
 	examplePath := "/path/to/destination/secret-file"
 	if a.isWindows {
@@ -205,12 +252,302 @@ func (a *Agent) ValidateConfigMap() error {
 		if slashCount < 2 {
 			return fmt.Errorf("template destination path must be a folder (e.g. %s)", examplePath)
 		}
+
+		if err := validateTemplateFormat(template); err != nil {
+			return err
+		}
+
+		if err := a.validateTemplateOwnership(template); err != nil {
+			return err
+		}
+
+		if err := a.validateTemplateOnChange(template); err != nil {
+			return err
+		}
+
+		if err := a.validateTemplateKubernetesSecret(template); err != nil {
+			return err
+		}
+	}
+
+	if a.pod.Annotations[util.AnnotationWaitForSecrets] == "true" && a.injectMode == util.InjectModeInit {
+		return fmt.Errorf("%s is only supported with inject mode %s or %s", util.AnnotationWaitForSecrets, util.InjectModeSidecar, util.InjectModeSidecarInit)
+	}
+
+	if err := a.validateEnvInjection(); err != nil {
+		return err
+	}
+
+	if _, err := a.templatesFSGroup(); err != nil {
+		return err
 	}
 
 	return nil
 
 }
 
+// validateTemplateFormat checks that a template is either generated from a format or written by the user, not both
+func validateTemplateFormat(template util.Template) error {
+	if template.Format == "" {
+		if template.Secrets != nil {
+			return fmt.Errorf("template %s sets secrets without a format, please set format to one of %s", template.DestinationPath, strings.Join(util.SupportedTemplateFormats, ", "))
+		}
+		return nil
+	}
+
+	if template.SourcePath != "" || template.TemplateContent != "" || template.Base64TemplateContent != "" {
+		return fmt.Errorf("template %s sets format %s, which can't be combined with source-path, template-content or base64-template-content", template.DestinationPath, template.Format)
+	}
+
+	if _, err := util.GenerateTemplateContent(template.Format, template.Secrets); err != nil {
+		return fmt.Errorf("invalid template %s: %w", template.DestinationPath, err)
+	}
+
+	// env templates are parsed as dotenv
+	if template.Env && template.Format != util.TemplateFormatDotenv {
+		return fmt.Errorf("template %s loads env vars, which requires format %s, got %s", template.DestinationPath, util.TemplateFormatDotenv, template.Format)
+	}
+
+	return nil
+}
+
+// validateTemplateOwnership checks that the agent is able to apply the file permissions, owner and group of a template.
+// the secret volumes are empty dirs which are writable by any user, so the only thing that can fail is changing the ownership of the rendered file.
+func (a *Agent) validateTemplateOwnership(template util.Template) error {
+	if template.FilePermissions == "" && template.Owner == nil && template.Group == nil {
+		return nil
+	}
+
+	if a.isWindows {
+		return fmt.Errorf("file-permissions, owner and group are not supported on windows pods (template destination: %s)", template.DestinationPath)
+	}
+
+	if template.FilePermissions != "" {
+		if _, err := util.ParseFilePermissions(template.FilePermissions); err != nil {
+			return fmt.Errorf("invalid file-permissions for template %s: %w", template.DestinationPath, err)
+		}
+	}
+
+	if template.Owner != nil && *template.Owner < 0 {
+		return fmt.Errorf("invalid owner for template %s, must be a positive number: %d", template.DestinationPath, *template.Owner)
+	}
+
+	if template.Group != nil && *template.Group < 0 {
+		return fmt.Errorf("invalid group for template %s, must be a positive number: %d", template.DestinationPath, *template.Group)
+	}
+
+	securityContext, err := a.SecurityContext()
+	if err != nil {
+		return fmt.Errorf("failed to get security context: %w", err)
+	}
+
+	// if we don't manage the security context, the agent runs as the user defined by the image and we can't know if it's allowed to chown
+	if securityContext == nil || securityContext.RunAsUser == nil {
+		return nil
+	}
+
+	runAsUser := *securityContext.RunAsUser
+	if runAsUser == 0 {
+		return nil
+	}
+
+	// all capabilities are dropped, so a non-root agent can only give the file to itself
+	if template.Owner != nil && *template.Owner != runAsUser {
+		return fmt.Errorf("agent running as user %d cannot set owner %d on %s. set %s to %d or run the agent as root", runAsUser, *template.Owner, template.DestinationPath, util.AnnotationSecurityContextRunAsUser, *template.Owner)
+	}
+
+	// a non-root agent can only change the group to one it is a member of, either its primary group or the pod fsGroup
+	if template.Group != nil {
+		isMember := securityContext.RunAsGroup != nil && *securityContext.RunAsGroup == *template.Group
+
+		// if the pod has no fsGroup we set it to the template group (see templatesFSGroup)
+		podSecurityContext := a.pod.Spec.SecurityContext
+		if podSecurityContext == nil || podSecurityContext.FSGroup == nil || *podSecurityContext.FSGroup == *template.Group {
+			isMember = true
+		}
+
+		if !isMember {
+			return fmt.Errorf("agent running as user %d is not a member of group %d and cannot set it on %s. set the pod fsGroup or %s to %d", runAsUser, *template.Group, template.DestinationPath, util.AnnotationSecurityContextRunAsGroup, *template.Group)
+		}
+	}
+
+	return nil
+}
+
+func (a *Agent) validateTemplateOnChange(template util.Template) error {
+	onChange := template.OnChange
+	if onChange == nil {
+		return nil
+	}
+
+	if a.injectMode == util.InjectModeInit {
+		return fmt.Errorf("on-change actions require inject mode %s or %s (template destination: %s)", util.InjectModeSidecar, util.InjectModeSidecarInit, template.DestinationPath)
+	}
+
+	actionCount := 0
+	if onChange.Command != "" {
+		actionCount++
+	}
+	if onChange.Signal != nil {
+		actionCount++
+	}
+	if onChange.HTTP != nil {
+		actionCount++
+	}
+	if actionCount != 1 {
+		return fmt.Errorf("on-change for template %s must have exactly one of command, signal or http", template.DestinationPath)
+	}
+
+	if onChange.Timeout < 0 {
+		return fmt.Errorf("invalid on-change timeout for template %s, must be a positive number: %d", template.DestinationPath, onChange.Timeout)
+	}
+
+	if onChange.Signal != nil {
+		if a.isWindows {
+			return fmt.Errorf("signal on-change actions are not supported on windows pods (template destination: %s)", template.DestinationPath)
+		}
+
+		signal := strings.ToUpper(onChange.Signal.Signal)
+		if !strings.HasPrefix(signal, "SIG") {
+			signal = "SIG" + signal
+		}
+		if !slices.Contains(util.SupportedOnChangeSignals, signal) {
+			return fmt.Errorf("on-change signal %s not supported. please use one of %s", onChange.Signal.Signal, strings.Join(util.SupportedOnChangeSignals, ", "))
+		}
+
+		if onChange.Signal.Process == "" {
+			return fmt.Errorf("on-change.signal.process is required for template %s", template.DestinationPath)
+		}
+
+		// all capabilities are dropped, so a non-root agent can only signal processes running as the same user
+		securityContext, err := a.SecurityContext()
+		if err != nil {
+			return fmt.Errorf("failed to get security context: %w", err)
+		}
+		if securityContext != nil && securityContext.RunAsUser != nil && *securityContext.RunAsUser != 0 {
+			agentRunAsUser := *securityContext.RunAsUser
+			signallable := slices.ContainsFunc(a.pod.Spec.Containers, func(container corev1.Container) bool {
+				containerRunAsUser := containerRunAsUser(a.pod, container)
+				return containerRunAsUser == nil || *containerRunAsUser == agentRunAsUser
+			})
+			if !signallable {
+				return fmt.Errorf("agent running as user %d cannot signal processes in containers running as other users (template destination: %s). set %s to the user of the app", agentRunAsUser, template.DestinationPath, util.AnnotationSecurityContextRunAsUser)
+			}
+		}
+
+		// the agent shell runs in the same process namespace and would be signalled as well
+		if slices.Contains(util.AgentShellProcesses, onChange.Signal.Process) {
+			return fmt.Errorf("on-change signal process %s for template %s would also signal the agent, please set the executable of the app process", onChange.Signal.Process, template.DestinationPath)
+		}
+	}
+
+	if onChange.HTTP != nil {
+		parsedURL, err := url.Parse(onChange.HTTP.URL)
+		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+			return fmt.Errorf("invalid on-change url for template %s, must be an absolute http(s) url: %s", template.DestinationPath, onChange.HTTP.URL)
+		}
+
+		method := strings.ToUpper(onChange.HTTP.Method)
+		if method != "" && method != "GET" && method != "POST" {
+			return fmt.Errorf("on-change http method %s not supported. please use GET or POST", onChange.HTTP.Method)
+		}
+	}
+
+	return nil
+}
+
+func (a *Agent) validateTemplateKubernetesSecret(template util.Template) error {
+	kubernetesSecret := template.KubernetesSecret
+	if kubernetesSecret == nil {
+		return nil
+	}
+
+	if a.isWindows {
+		return fmt.Errorf("kubernetes secret sinks are not supported on windows pods (template destination: %s)", template.DestinationPath)
+	}
+
+	if util.GetInjectorImage() == "" {
+		return fmt.Errorf("kubernetes secret sinks require the %s env var to be set on the injector", util.EnvInjectorImage)
+	}
+
+	if errs := validation.IsDNS1123Subdomain(kubernetesSecret.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid kubernetes secret name %q for template %s: %s", kubernetesSecret.Name, template.DestinationPath, strings.Join(errs, ", "))
+	}
+
+	if kubernetesSecret.Format != "" && kubernetesSecret.Format != util.KubernetesSecretFormatFile && kubernetesSecret.Format != util.KubernetesSecretFormatDotenv {
+		return fmt.Errorf("kubernetes secret format %s not supported. please use %s or %s", kubernetesSecret.Format, util.KubernetesSecretFormatFile, util.KubernetesSecretFormatDotenv)
+	}
+
+	if kubernetesSecret.Key != "" {
+		if errs := validation.IsConfigMapKey(kubernetesSecret.Key); len(errs) > 0 {
+			return fmt.Errorf("invalid kubernetes secret key %q for template %s: %s", kubernetesSecret.Key, template.DestinationPath, strings.Join(errs, ", "))
+		}
+	}
+
+	// secret-only templates are removed from the app containers by volume, so they can't share a folder with a mounted template
+	if kubernetesSecret.Only {
+		destinationDir := path.Dir(template.DestinationPath, a.isWindows)
+		for _, otherTemplate := range a.configMap.Templates {
+			if otherTemplate.KubernetesSecret != nil && otherTemplate.KubernetesSecret.Only {
+				continue
+			}
+			if path.Dir(otherTemplate.DestinationPath, a.isWindows) == destinationDir {
+				return fmt.Errorf("template %s is only written to a kubernetes secret and cannot share its folder with template %s", template.DestinationPath, otherTemplate.DestinationPath)
+			}
+		}
+	}
+
+	return nil
+}
+
+// containerRunAsUser returns the user the container runs as, if it's set on the container or the pod
+func containerRunAsUser(pod *corev1.Pod, container corev1.Container) *int64 {
+	if container.SecurityContext != nil && container.SecurityContext.RunAsUser != nil {
+		return container.SecurityContext.RunAsUser
+	}
+	if pod.Spec.SecurityContext != nil {
+		return pod.Spec.SecurityContext.RunAsUser
+	}
+	return nil
+}
+
+// requiresSharedProcessNamespace returns true if any template signals a process in another container
+func (a *Agent) requiresSharedProcessNamespace() bool {
+	return slices.ContainsFunc(a.configMap.Templates, func(template util.Template) bool {
+		return template.OnChange != nil && template.OnChange.Signal != nil
+	})
+}
+
+// templatesFSGroup returns the fsGroup that should be set on the pod so the agent and the app share the group of the rendered files.
+// returns nil if no template has a group, or if the pod already defines its own fsGroup.
+func (a *Agent) templatesFSGroup() (*int64, error) {
+	var fsGroup *int64
+
+	for _, template := range a.configMap.Templates {
+		if template.Group == nil {
+			continue
+		}
+
+		if fsGroup != nil && *fsGroup != *template.Group {
+			return nil, fmt.Errorf("templates have conflicting groups (%d and %d), only one fsGroup can be set per pod", *fsGroup, *template.Group)
+		}
+		fsGroup = template.Group
+	}
+
+	if fsGroup == nil {
+		return nil, nil
+	}
+
+	if a.pod.Spec.SecurityContext != nil && a.pod.Spec.SecurityContext.FSGroup != nil {
+		if *a.pod.Spec.SecurityContext.FSGroup != *fsGroup {
+			a.logger.Info("Pod already has an fsGroup, not overriding it with the template group", "fs_group", *a.pod.Spec.SecurityContext.FSGroup, "template_group", *fsGroup)
+		}
+		return nil, nil
+	}
+
+	return fsGroup, nil
+}
+
 func (a *Agent) PatchPod() ([]byte, error) {
 	var podPatches jsonpatch.Patch
 
@@ -218,10 +555,17 @@ func (a *Agent) PatchPod() ([]byte, error) {
 	for i, container := range a.pod.Spec.Containers {
 		podPatches = append(podPatches, addVolumeMounts(
 			container.VolumeMounts,
-			a.ContainerVolumeMounts(container.VolumeMounts),
+			a.AppContainerVolumeMounts(container.VolumeMounts),
 			fmt.Sprintf("/spec/containers/%d/volumeMounts", i))...)
 	}
 
+	// 2. wrap the entrypoint of the app containers that load env templates
+	envPatches, err := a.envWrapperPatches()
+	if err != nil {
+		return nil, err
+	}
+	podPatches = append(podPatches, envPatches...)
+
 	if err := util.ValidateInjectMode(a.injectMode); err != nil {
 		return nil, err
 	}
@@ -237,14 +581,31 @@ func (a *Agent) PatchPod() ([]byte, error) {
 
 	templateCount := len(a.configMap.Templates)
 	for i := range a.configMap.Templates {
-		var name string
-		if templateCount > 1 {
-			name = fmt.Sprintf("infisical-secrets-%d", i+1)
-		} else {
-			name = "infisical-secrets"
-		}
 		requiredVolumes = append(requiredVolumes, corev1.Volume{
-			Name: name,
+			Name: secretVolumeName(i, templateCount),
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+	}
+
+	if len(a.envTemplatePaths()) > 0 {
+		requiredVolumes = append(requiredVolumes, corev1.Volume{
+			Name: util.EnvWrapperVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+	}
+
+	if tlsVolume := a.tlsVolume(); tlsVolume != nil {
+		requiredVolumes = append(requiredVolumes, *tlsVolume)
+	}
+
+	// only the init agent writes its output to a file
+	if a.injectMode == util.InjectModeInit || a.injectMode == util.InjectModeSidecarInit {
+		requiredVolumes = append(requiredVolumes, corev1.Volume{
+			Name: util.ContainerAgentLogVolumeName,
 			VolumeSource: corev1.VolumeSource{
 				EmptyDir: &corev1.EmptyDirVolumeSource{},
 			},
@@ -256,19 +617,53 @@ func (a *Agent) PatchPod() ([]byte, error) {
 		requiredVolumes,
 		"/spec/volumes")...)
 
+	// pull secrets for the injected images, if their registry has any configured on the injector
+	pullSecrets, err := a.ImagePullSecrets()
+	if err != nil {
+		return nil, err
+	}
+	var pullSecretReferences []corev1.LocalObjectReference
+	for _, secret := range pullSecrets {
+		pullSecretReferences = append(pullSecretReferences, corev1.LocalObjectReference{Name: secret})
+	}
+	podPatches = append(podPatches, addImagePullSecrets(
+		a.pod.Spec.ImagePullSecrets,
+		pullSecretReferences,
+		"/spec/imagePullSecrets")...)
+
+	// make the secret volumes group-owned by the templates group, so the app can read files owned by that group
+	fsGroup, err := a.templatesFSGroup()
+	if err != nil {
+		return nil, err
+	}
+	if fsGroup != nil {
+		podPatches = append(podPatches, updatePodFSGroup(a.pod.Spec.SecurityContext, *fsGroup)...)
+	}
+
+	// the agent can only see processes of the app containers if they share a process namespace
+	if a.requiresSharedProcessNamespace() && (a.pod.Spec.ShareProcessNamespace == nil || !*a.pod.Spec.ShareProcessNamespace) {
+		podPatches = append(podPatches, AddOp("/spec/shareProcessNamespace", true))
+	}
+
 	switch a.injectMode {
 	case util.InjectModeInit:
-		err := a.appendInitContainer(&podPatches)
+		err := a.appendInitContainers(&podPatches, true)
 		if err != nil {
 			return nil, err
 		}
 	case util.InjectModeSidecar:
-		err := a.appendSidecarContainer(&podPatches)
+		// the env wrapper is copied by an init container, even when the agent only runs as a sidecar
+		err := a.appendInitContainers(&podPatches, false)
+		if err != nil {
+			return nil, err
+		}
+
+		err = a.appendSidecarContainer(&podPatches)
 		if err != nil {
 			return nil, err
 		}
 	case util.InjectModeSidecarInit:
-		err := a.appendInitContainer(&podPatches)
+		err := a.appendInitContainers(&podPatches, true)
 		if err != nil {
 			return nil, err
 		}
@@ -279,9 +674,21 @@ func (a *Agent) PatchPod() ([]byte, error) {
 		}
 	}
 
+	annotations := map[string]string{util.AnnotationAgentStatus: "injected"}
+	if a.configMap.Hash != "" {
+		annotations[util.AnnotationAgentConfigHash] = a.configMap.Hash
+	}
+	if a.configMap.Source != "" {
+		annotations[util.AnnotationAgentConfigSource] = a.configMap.Source
+	}
+
 	podPatches = append(podPatches, updatePodAnnotations(
 		a.pod.Annotations,
-		map[string]string{util.AnnotationAgentStatus: "injected"})...)
+		annotations)...)
+
+	podPatches = append(podPatches, updatePodLabels(
+		a.pod.Labels,
+		map[string]string{util.LabelAgentInjected: "true"})...)
 
 	if len(podPatches) > 0 {
 		return json.Marshal(podPatches)
@@ -290,25 +697,89 @@ func (a *Agent) PatchPod() ([]byte, error) {
 
 }
 
-func (a *Agent) Lifecycle() corev1.Lifecycle {
+func (a *Agent) Lifecycle() (corev1.Lifecycle, error) {
 
 	// No preStop needed - k8s will send SIGTERM automatically
 	// k8s sends the sigterm to PID 1, so in the startup script we forward the signal to the agent process (see linux-container-startup.sh.tmpl)
 
-	return corev1.Lifecycle{}
+	waitForSecrets, err := a.waitForSecrets()
+	if err != nil {
+		return corev1.Lifecycle{}, err
+	}
+
+	if !waitForSecrets {
+		return corev1.Lifecycle{}, nil
+	}
+
+	// the kubelet starts containers in order and doesn't start the next one until the postStart hook has completed,
+	// so with the sidecar first in the pod, the app containers only start once the secrets are rendered
+	timeoutSeconds, err := util.GetInitTimeoutSeconds(a.configMap, a.pod.Annotations)
+	if err != nil {
+		return corev1.Lifecycle{}, err
+	}
+
+	var destinationPaths []string
+	for _, template := range a.configMap.Templates {
+		destinationPaths = append(destinationPaths, template.DestinationPath)
+	}
+
+	return corev1.Lifecycle{
+		PostStart: &corev1.LifecycleHandler{
+			Exec: &corev1.ExecAction{
+				Command: a.waitForFilesCommand(destinationPaths, timeoutSeconds),
+			},
+		},
+	}, nil
 }
 
-func (a *Agent) appendInitContainer(podPatches *jsonpatch.Patch) error {
-	container, err := a.ContainerInitSidecar()
+// waitForSecrets returns true if the app containers should wait for the sidecar to render the secrets before starting
+func (a *Agent) waitForSecrets() (bool, error) {
+	waitForSecrets, err := util.ParseStringToBool(a.pod.Annotations[util.AnnotationWaitForSecrets], false)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("failed to parse wait for secrets annotation: %w", err)
+	}
+
+	return waitForSecrets && a.injectMode != util.InjectModeInit, nil
+}
+
+func (a *Agent) appendInitContainers(podPatches *jsonpatch.Patch, withAgent bool) error {
+	containers := []corev1.Container{}
+
+	if len(a.envTemplatePaths()) > 0 {
+		envWrapperContainer, err := a.ContainerEnvWrapperInit()
+		if err != nil {
+			return err
+		}
+		containers = append(containers, envWrapperContainer)
+	}
+
+	if withAgent {
+		container, err := a.ContainerInitSidecar()
+		if err != nil {
+			return err
+		}
+		containers = append(containers, container)
+
+		// the secret sync runs after the agent has rendered the templates, and before the app init containers that may read the secret
+		if len(a.SecretSyncTargets()) > 0 {
+			secretSyncContainer, err := a.ContainerSecretSync(true)
+			if err != nil {
+				return err
+			}
+			containers = append(containers, secretSyncContainer)
+		}
+	}
+
+	if len(containers) == 0 {
+		return nil
 	}
 
 	if len(a.pod.Spec.InitContainers) != 0 {
 		*podPatches = append(*podPatches, removeContainers("/spec/initContainers")...)
 	}
 
-	containers := append([]corev1.Container{container}, a.pod.Spec.InitContainers...)
+	injectedCount := len(containers)
+	containers = append(containers, a.pod.Spec.InitContainers...)
 
 	*podPatches = append(*podPatches, addContainers(
 		[]corev1.Container{},
@@ -316,12 +787,12 @@ func (a *Agent) appendInitContainer(podPatches *jsonpatch.Patch) error {
 		"/spec/initContainers")...)
 
 	for i, container := range containers {
-		if container.Name == util.InitContainerName {
+		if i < injectedCount {
 			continue
 		}
 		*podPatches = append(*podPatches, addVolumeMounts(
 			container.VolumeMounts,
-			a.ContainerVolumeMounts(container.VolumeMounts),
+			a.AppContainerVolumeMounts(container.VolumeMounts),
 			fmt.Sprintf("/spec/initContainers/%d/volumeMounts", i))...)
 	}
 	return nil
@@ -332,145 +803,152 @@ func (a *Agent) appendSidecarContainer(podPatches *jsonpatch.Patch) error {
 	if err != nil {
 		return err
 	}
+	containers := []corev1.Container{container}
+
+	if len(a.SecretSyncTargets()) > 0 {
+		secretSyncContainer, err := a.ContainerSecretSync(false)
+		if err != nil {
+			return err
+		}
+		containers = append(containers, secretSyncContainer)
+	}
+
+	waitForSecrets, err := a.waitForSecrets()
+	if err != nil {
+		return err
+	}
+
+	// the agent must start before the app containers for its postStart hook to block them
+	if waitForSecrets {
+		*podPatches = append(*podPatches, AddOp("/spec/containers/0", container))
+		containers = containers[1:]
+	}
+
 	*podPatches = append(*podPatches, addContainers(
 		a.pod.Spec.Containers,
-		[]corev1.Container{container},
+		containers,
 		"/spec/containers")...)
 	return nil
 }
 
-func (a *Agent) ResourceRequirements() (corev1.ResourceRequirements, error) {
-
-	const (
-		// CPU, Linux
-		DefaultCPULimitLinux   = "500m"
-		DefaultCPURequestLinux = "100m"
-
-		// Memory, Linux
-		DefaultMemoryLimitLinux   = "128Mi"
-		DefaultMemoryRequestLinux = "64Mi"
-
-		// CPU, Windows
-		DefaultCPULimitWindows   = "500m"
-		DefaultCPURequestWindows = "100m"
-
-		// Memory, Windows
-		DefaultMemoryLimitWindows   = "512Mi"
-		DefaultMemoryRequestWindows = "256Mi"
-	)
+// resourceAnnotation returns the value of the resource annotation for the container role, falling back to the annotation without a role
+func (a *Agent) resourceAnnotation(role string, annotation string) string {
+	roleAnnotation := util.AnnotationAgentPrefix + role + "-" + strings.TrimPrefix(annotation, util.AnnotationAgentPrefix)
+	if value := a.pod.Annotations[roleAnnotation]; value != "" {
+		return value
+	}
+	return a.pod.Annotations[annotation]
+}
 
-	limits := corev1.ResourceList{}
-	requests := corev1.ResourceList{}
+// ResourceRequirements returns the resources of the injected containers with the given role (init or sidecar)
+func (a *Agent) ResourceRequirements(role string) (corev1.ResourceRequirements, error) {
 
-	var cpuLimit, cpuRequest resource.Quantity
-	var memoryLimit, memoryRequest resource.Quantity
+	profileName := a.resourceAnnotation(role, util.AnnotationResourceProfile)
+	if profileName == "" {
+		profileName = util.ResourceProfileDefault
+	}
 
-	// we don't set defaults for ephemeral storage as it first became generally available in k8s 1.25.
-	// additionally we want to let the cluster dictacte the pods ephemeral storage limits if not explicitly provided by the user.
-	var ephemeralLimit, ephemeralRequest resource.Quantity
-	var ephemeralLimitSet, ephemeralRequestSet bool
+	profile, err := util.GetResourceProfile(a.configMap, profileName)
+	if err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
 
-	// default limits
 	if a.isWindows {
-		// windows pods need much more memory than linux pods (this resolves out of memory restarts)
-		memoryLimit, _ = resource.ParseQuantity(DefaultMemoryLimitWindows)     // 4x more
-		memoryRequest, _ = resource.ParseQuantity(DefaultMemoryRequestWindows) // 2x more
-		cpuLimit, _ = resource.ParseQuantity(DefaultCPULimitWindows)
-		cpuRequest, _ = resource.ParseQuantity(DefaultCPURequestWindows)
-	} else {
-		memoryLimit, _ = resource.ParseQuantity(DefaultMemoryLimitLinux)
-		memoryRequest, _ = resource.ParseQuantity(DefaultMemoryRequestLinux)
-		cpuLimit, _ = resource.ParseQuantity(DefaultCPULimitLinux)
-		cpuRequest, _ = resource.ParseQuantity(DefaultCPURequestLinux)
+		profile = windowsResourceProfile(profile, profileName)
 	}
 
-	// user-defined ephemeral storage limits
-	if a.pod.Annotations[util.AnnotationLimitsEphemeral] != "" {
-		limit, err := resource.ParseQuantity(a.pod.Annotations[util.AnnotationLimitsEphemeral])
-		if err != nil {
-			return corev1.ResourceRequirements{}, fmt.Errorf("failed to parse ephemeral limit: %w", err)
-		}
-		ephemeralLimit = limit
-		ephemeralLimitSet = true
+	requests, err := profile.Requests.ResourceList()
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("invalid requests in resource profile %s: %w", profileName, err)
 	}
 
-	// user-defined ephemeral storage requests
-	if a.pod.Annotations[util.AnnotationRequestsEphemeral] != "" {
-		request, err := resource.ParseQuantity(a.pod.Annotations[util.AnnotationRequestsEphemeral])
+	limits := corev1.ResourceList{}
+	if profile.Limits != nil {
+		limits, err = profile.Limits.ResourceList()
 		if err != nil {
-			return corev1.ResourceRequirements{}, fmt.Errorf("failed to parse ephemeral request: %w", err)
+			return corev1.ResourceRequirements{}, fmt.Errorf("invalid limits in resource profile %s: %w", profileName, err)
 		}
-		ephemeralRequest = request
-		ephemeralRequestSet = true
 	}
 
-	// user-defined CPU limits
-	if a.pod.Annotations[util.AnnotationLimitsCPU] != "" {
-		limit, err := resource.ParseQuantity(a.pod.Annotations[util.AnnotationLimitsCPU])
-		if err != nil {
-			return corev1.ResourceRequirements{}, fmt.Errorf("failed to parse cpu limit: %w", err)
-		}
-		cpuLimit = limit
+	// user-defined limits and requests
+	overrides := []struct {
+		annotation string
+		name       corev1.ResourceName
+		target     corev1.ResourceList
+		kind       string
+	}{
+		{util.AnnotationLimitsCPU, corev1.ResourceCPU, limits, "limit"},
+		{util.AnnotationRequestsCPU, corev1.ResourceCPU, requests, "request"},
+		{util.AnnotationLimitsMemory, corev1.ResourceMemory, limits, "limit"},
+		{util.AnnotationRequestsMemory, corev1.ResourceMemory, requests, "request"},
+		{util.AnnotationLimitsEphemeral, corev1.ResourceEphemeralStorage, limits, "limit"},
+		{util.AnnotationRequestsEphemeral, corev1.ResourceEphemeralStorage, requests, "request"},
 	}
 
-	// user-defined CPU requests
-	if a.pod.Annotations[util.AnnotationRequestsCPU] != "" {
-		request, err := resource.ParseQuantity(a.pod.Annotations[util.AnnotationRequestsCPU])
-		if err != nil {
-			return corev1.ResourceRequirements{}, fmt.Errorf("failed to parse cpu request: %w", err)
+	for _, override := range overrides {
+		value := a.resourceAnnotation(role, override.annotation)
+		if value == "" {
+			continue
 		}
-		cpuRequest = request
-	}
 
-	// user-defined memory limits
-	if a.pod.Annotations[util.AnnotationLimitsMemory] != "" {
-		limit, err := resource.ParseQuantity(a.pod.Annotations[util.AnnotationLimitsMemory])
+		quantity, err := resource.ParseQuantity(value)
 		if err != nil {
-			return corev1.ResourceRequirements{}, fmt.Errorf("failed to parse memory limit: %w", err)
+			return corev1.ResourceRequirements{}, fmt.Errorf("failed to parse %s %s %s: %w", role, override.name, override.kind, err)
 		}
-		memoryLimit = limit
+		override.target[override.name] = quantity
 	}
 
-	// user-defined memory requests
-	if a.pod.Annotations[util.AnnotationRequestsMemory] != "" {
-		request, err := resource.ParseQuantity(a.pod.Annotations[util.AnnotationRequestsMemory])
-		if err != nil {
-			return corev1.ResourceRequirements{}, fmt.Errorf("failed to parse memory request: %w", err)
+	for name, request := range requests {
+		if limit, exists := limits[name]; exists && request.Cmp(limit) > 0 {
+			return corev1.ResourceRequirements{}, fmt.Errorf("%s %s request %s is greater than the %s limit %s (resource profile %s)", role, name, request.String(), name, limit.String(), profileName)
 		}
-		memoryRequest = request
 	}
 
-	limits[corev1.ResourceCPU] = cpuLimit
-	limits[corev1.ResourceMemory] = memoryLimit
-	if ephemeralLimitSet {
-		limits[corev1.ResourceEphemeralStorage] = ephemeralLimit
+	resources := corev1.ResourceRequirements{
+		Requests: requests,
 	}
 
-	requests[corev1.ResourceCPU] = cpuRequest
-	requests[corev1.ResourceMemory] = memoryRequest
-	if ephemeralRequestSet {
-		requests[corev1.ResourceEphemeralStorage] = ephemeralRequest
+	if len(limits) > 0 {
+		resources.Limits = limits
+	} else {
+		a.warn("%s agent containers have no resource limits (resource profile %s)", role, profileName)
 	}
 
-	// set the limits and requests on the resource requirements
+	return resources, nil
+}
 
-	resources := corev1.ResourceRequirements{
-		Limits:   limits,
-		Requests: requests,
+// windowsResourceProfile returns the windows values of the profile. custom profiles often only set the linux values, which are too low for windows,
+// so they fall back to the windows values of the built-in profile with the same name, or the default profile
+func windowsResourceProfile(profile util.ResourceProfile, profileName string) util.ResourceProfile {
+	if profile.Windows != nil {
+		return *profile.Windows
 	}
 
-	return resources, nil
+	if builtInProfile, exists := util.DefaultResourceProfiles[profileName]; exists && builtInProfile.Windows != nil {
+		return *builtInProfile.Windows
+	}
+
+	return *util.DefaultResourceProfiles[util.ResourceProfileDefault].Windows
 }
 
 func (a *Agent) SecurityContext() (*corev1.SecurityContext, error) {
 
-	setSecurityContext, err := util.ParseStringToBool(a.pod.Annotations[util.AnnotationSetSecurityContext], false)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse set security context annotation: %w", err)
+	setSecurityContextValue := a.pod.Annotations[util.AnnotationSetSecurityContext]
+	inherit := strings.EqualFold(setSecurityContextValue, util.SecurityContextModeInherit)
+
+	if !inherit {
+		setSecurityContext, err := util.ParseStringToBool(setSecurityContextValue, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse set security context annotation: %w", err)
+		}
+
+		if !setSecurityContext {
+			return nil, nil
+		}
 	}
 
-	if !setSecurityContext {
-		return nil, nil
+	if inherit && a.isWindows {
+		return a.inheritedWindowsSecurityContext(), nil
 	}
 
 	readOnlyRootFilesystem, err := util.ParseStringToBool(a.pod.Annotations[util.AnnotationSecurityContextReadOnlyRootFilesystem], true)
@@ -478,28 +956,49 @@ func (a *Agent) SecurityContext() (*corev1.SecurityContext, error) {
 		return nil, fmt.Errorf("failed to parse security context read only root filesystem annotation: %w", err)
 	}
 
-	runAsUser, err := util.ParseStringToInt(a.pod.Annotations[util.AnnotationSecurityContextRunAsUser], 1000)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse security context run as user: %w", err)
+	runAsUser := pointer.Int64(util.DefaultSecurityContextRunAsUser)
+	runAsGroup := pointer.Int64(util.DefaultSecurityContextRunAsGroup)
+
+	// nothing is set if the app doesn't set a user or group either, platforms like openshift assign them from the namespace range
+	if inherit {
+		runAsUser, runAsGroup = a.inheritedRunAsUserAndGroup()
 	}
 
-	runAsGroup, err := util.ParseStringToInt(a.pod.Annotations[util.AnnotationSecurityContextRunAsGroup], 2000)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse security context run as group: %w", err)
+	// the annotations still take precedence over the inherited values
+	if a.pod.Annotations[util.AnnotationSecurityContextRunAsUser] != "" {
+		value, err := util.ParseStringToInt(a.pod.Annotations[util.AnnotationSecurityContextRunAsUser], 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse security context run as user: %w", err)
+		}
+		runAsUser = pointer.Int64(int64(value))
 	}
 
-	runAsNonRoot := true
+	if a.pod.Annotations[util.AnnotationSecurityContextRunAsGroup] != "" {
+		value, err := util.ParseStringToInt(a.pod.Annotations[util.AnnotationSecurityContextRunAsGroup], 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse security context run as group: %w", err)
+		}
+		runAsGroup = pointer.Int64(int64(value))
+	}
 
-	// if any of these are true, we run as root
-	// we default to non-root if not explicitly set
-	if runAsUser == 0 || runAsGroup == 0 {
-		runAsNonRoot = false
+	var runAsNonRoot *bool
+	if inherit {
+		// only the user decides whether the agent runs as root, e.g. openshift assigns group 0 to non-root users
+		if runAsUser != nil {
+			runAsNonRoot = pointer.Bool(*runAsUser != 0)
+		} else {
+			runAsNonRoot = a.inheritedRunAsNonRoot()
+		}
+	} else {
+		// if any of these are true, we run as root
+		// we default to non-root if not explicitly set
+		runAsNonRoot = pointer.Bool(*runAsUser != 0 && *runAsGroup != 0)
 	}
 
 	securityContext := &corev1.SecurityContext{
-		RunAsUser:    pointer.Int64(int64(runAsUser)),
-		RunAsGroup:   pointer.Int64(int64(runAsGroup)),
-		RunAsNonRoot: pointer.Bool(runAsNonRoot),
+		RunAsUser:    runAsUser,
+		RunAsGroup:   runAsGroup,
+		RunAsNonRoot: runAsNonRoot,
 		Capabilities: &corev1.Capabilities{
 			Drop: []corev1.Capability{"ALL"},
 		},
@@ -512,6 +1011,88 @@ func (a *Agent) SecurityContext() (*corev1.SecurityContext, error) {
 		securityContext.ReadOnlyRootFilesystem = pointer.Bool(readOnlyRootFilesystem)
 	}
 
+	if inherit {
+		securityContext.SeccompProfile = a.inheritedSeccompProfile()
+	}
+
 	return securityContext, nil
 
 }
+
+// primaryContainer returns the container named by the kubectl default container annotation, or the first app container
+func (a *Agent) primaryContainer() *corev1.Container {
+	if name := a.pod.Annotations[util.AnnotationDefaultContainer]; name != "" {
+		for i := range a.pod.Spec.Containers {
+			if a.pod.Spec.Containers[i].Name == name {
+				return &a.pod.Spec.Containers[i]
+			}
+		}
+	}
+
+	if len(a.pod.Spec.Containers) == 0 {
+		return nil
+	}
+	return &a.pod.Spec.Containers[0]
+}
+
+// inheritedRunAsUserAndGroup returns the user and group the primary container runs as, container values take precedence over the pod values.
+// on openshift these are set by the SCC admission plugin before the webhook is called, so the agent gets the same random uid as the app.
+// the pod fsGroup doesn't need to be inherited, it applies to every container in the pod
+func (a *Agent) inheritedRunAsUserAndGroup() (*int64, *int64) {
+	var runAsUser, runAsGroup *int64
+
+	if podSecurityContext := a.pod.Spec.SecurityContext; podSecurityContext != nil {
+		runAsUser = podSecurityContext.RunAsUser
+		runAsGroup = podSecurityContext.RunAsGroup
+	}
+
+	if container := a.primaryContainer(); container != nil && container.SecurityContext != nil {
+		if container.SecurityContext.RunAsUser != nil {
+			runAsUser = container.SecurityContext.RunAsUser
+		}
+		if container.SecurityContext.RunAsGroup != nil {
+			runAsGroup = container.SecurityContext.RunAsGroup
+		}
+	}
+
+	return runAsUser, runAsGroup
+}
+
+// inheritedRunAsNonRoot returns the runAsNonRoot of the primary container or the pod, for apps that don't set a user
+func (a *Agent) inheritedRunAsNonRoot() *bool {
+	if container := a.primaryContainer(); container != nil && container.SecurityContext != nil && container.SecurityContext.RunAsNonRoot != nil {
+		return pointer.Bool(*container.SecurityContext.RunAsNonRoot)
+	}
+
+	if a.pod.Spec.SecurityContext != nil && a.pod.Spec.SecurityContext.RunAsNonRoot != nil {
+		return pointer.Bool(*a.pod.Spec.SecurityContext.RunAsNonRoot)
+	}
+
+	return nil
+}
+
+// inheritedSeccompProfile returns the seccomp profile of the primary container, or RuntimeDefault if neither the container nor the pod set one
+func (a *Agent) inheritedSeccompProfile() *corev1.SeccompProfile {
+	if container := a.primaryContainer(); container != nil && container.SecurityContext != nil && container.SecurityContext.SeccompProfile != nil {
+		return container.SecurityContext.SeccompProfile.DeepCopy()
+	}
+
+	// the pod profile already applies to the agent containers
+	if a.pod.Spec.SecurityContext != nil && a.pod.Spec.SecurityContext.SeccompProfile != nil {
+		return nil
+	}
+
+	return &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+}
+
+// inheritedWindowsSecurityContext copies the windows options of the primary container, the linux only fields can't be set on windows pods
+func (a *Agent) inheritedWindowsSecurityContext() *corev1.SecurityContext {
+	container := a.primaryContainer()
+	if container == nil || container.SecurityContext == nil || container.SecurityContext.WindowsOptions == nil {
+		return nil
+	}
+
+	return &corev1.SecurityContext{
+		WindowsOptions: container.SecurityContext.WindowsOptions.DeepCopy(),
+	}
+}
@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestResourceRequirements(t *testing.T) {
+	tests := []struct {
+		name           string
+		annotations    map[string]string
+		podOS          string
+		configProfiles map[string]util.ResourceProfile
+		role           string
+		wantRequests   map[corev1.ResourceName]string
+		wantLimits     map[corev1.ResourceName]string
+		wantWarning    bool
+		wantErr        string
+	}{
+		{
+			name:         "default profile",
+			podOS:        util.OSLinux,
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "100m", corev1.ResourceMemory: "64Mi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceCPU: "500m", corev1.ResourceMemory: "128Mi"},
+		},
+		{
+			name:         "default profile on windows",
+			podOS:        util.OSWindows,
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "100m", corev1.ResourceMemory: "256Mi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceCPU: "500m", corev1.ResourceMemory: "512Mi"},
+		},
+		{
+			name:         "built-in profile",
+			annotations:  map[string]string{util.AnnotationResourceProfile: util.ResourceProfileLarge},
+			podOS:        util.OSLinux,
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "250m", corev1.ResourceMemory: "128Mi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceCPU: "1", corev1.ResourceMemory: "256Mi"},
+		},
+		{
+			name:           "config map profile without limits",
+			annotations:    map[string]string{util.AnnotationResourceProfile: "tiny"},
+			podOS:          util.OSLinux,
+			configProfiles: map[string]util.ResourceProfile{"tiny": {Requests: util.ResourceValues{CPU: "25m", Memory: "32Mi"}}},
+			wantRequests:   map[corev1.ResourceName]string{corev1.ResourceCPU: "25m", corev1.ResourceMemory: "32Mi"},
+			wantWarning:    true,
+		},
+		{
+			name:           "custom profile on windows falls back to the default windows values",
+			annotations:    map[string]string{util.AnnotationResourceProfile: "tiny"},
+			podOS:          util.OSWindows,
+			configProfiles: map[string]util.ResourceProfile{"tiny": {Requests: util.ResourceValues{CPU: "25m", Memory: "32Mi"}}},
+			wantRequests:   map[corev1.ResourceName]string{corev1.ResourceCPU: "100m", corev1.ResourceMemory: "256Mi"},
+			wantLimits:     map[corev1.ResourceName]string{corev1.ResourceCPU: "500m", corev1.ResourceMemory: "512Mi"},
+		},
+		{
+			name:         "annotations override the profile",
+			annotations:  map[string]string{util.AnnotationRequestsCPU: "200m", util.AnnotationLimitsMemory: "1Gi"},
+			podOS:        util.OSLinux,
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "200m", corev1.ResourceMemory: "64Mi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceCPU: "500m", corev1.ResourceMemory: "1Gi"},
+		},
+		{
+			name:         "sidecar annotations take precedence",
+			annotations:  map[string]string{util.AnnotationRequestsCPU: "200m", "org.infisical.com/agent-sidecar-requests-cpu": "50m"},
+			podOS:        util.OSLinux,
+			role:         util.ContainerRoleSidecar,
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "50m", corev1.ResourceMemory: "64Mi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceCPU: "500m", corev1.ResourceMemory: "128Mi"},
+		},
+		{
+			name:         "sidecar annotations don't apply to the init container",
+			annotations:  map[string]string{"org.infisical.com/agent-sidecar-requests-cpu": "50m"},
+			podOS:        util.OSLinux,
+			role:         util.ContainerRoleInit,
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "100m", corev1.ResourceMemory: "64Mi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceCPU: "500m", corev1.ResourceMemory: "128Mi"},
+		},
+		{
+			name:         "init profile",
+			annotations:  map[string]string{"org.infisical.com/agent-init-resource-profile": util.ResourceProfileSmall},
+			podOS:        util.OSLinux,
+			role:         util.ContainerRoleInit,
+			wantRequests: map[corev1.ResourceName]string{corev1.ResourceCPU: "50m", corev1.ResourceMemory: "32Mi"},
+			wantLimits:   map[corev1.ResourceName]string{corev1.ResourceCPU: "250m", corev1.ResourceMemory: "64Mi"},
+		},
+		{
+			name:        "unknown profile",
+			annotations: map[string]string{util.AnnotationResourceProfile: "huge"},
+			podOS:       util.OSLinux,
+			wantErr:     "resource profile huge not found",
+		},
+		{
+			name:        "request above the limit",
+			annotations: map[string]string{util.AnnotationRequestsCPU: "2"},
+			podOS:       util.OSLinux,
+			wantErr:     "cpu request 2 is greater than the cpu limit 500m",
+		},
+		{
+			name:        "invalid quantity",
+			annotations: map[string]string{util.AnnotationLimitsMemory: "lots"},
+			podOS:       util.OSLinux,
+			wantErr:     "failed to parse init memory limit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := tt.role
+			if role == "" {
+				role = util.ContainerRoleInit
+			}
+
+			agent := newTestAgent(t, tt.annotations, tt.podOS, "/shared/secrets")
+			agent.configMap.ResourceProfiles = tt.configProfiles
+
+			got, err := agent.ResourceRequirements(role)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertResourceList(t, "requests", got.Requests, tt.wantRequests)
+			assertResourceList(t, "limits", got.Limits, tt.wantLimits)
+
+			if gotWarning := len(agent.Warnings()) > 0; gotWarning != tt.wantWarning {
+				t.Errorf("got warnings %q, want warning %v", agent.Warnings(), tt.wantWarning)
+			}
+		})
+	}
+}
+
+func assertResourceList(t *testing.T, kind string, got corev1.ResourceList, want map[corev1.ResourceName]string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("got %s %v, want %v", kind, got, want)
+		return
+	}
+	for name, value := range want {
+		if quantity, exists := got[name]; !exists || quantity.String() != value {
+			t.Errorf("got %s %s %v, want %s", kind, name, got[name], value)
+		}
+	}
+}
+
+func TestValidateTemplateFormat(t *testing.T) {
+	secrets := &util.TemplateSecrets{ProjectID: "project", Environment: "dev"}
+
+	tests := []struct {
+		name     string
+		template util.Template
+		wantErr  string
+	}{
+		{
+			name:     "user template",
+			template: util.Template{DestinationPath: "/app/config", TemplateContent: "{{ .Value }}"},
+		},
+		{
+			name:     "format",
+			template: util.Template{DestinationPath: "/app/secrets.json", Format: util.TemplateFormatJSON, Secrets: secrets},
+		},
+		{
+			name:     "dotenv env template",
+			template: util.Template{DestinationPath: "/app/.env", Format: util.TemplateFormatDotenv, Secrets: secrets, Env: true},
+		},
+		{
+			name:     "secrets without format",
+			template: util.Template{DestinationPath: "/app/config", TemplateContent: "{{ .Value }}", Secrets: secrets},
+			wantErr:  "sets secrets without a format",
+		},
+		{
+			name:     "format with template content",
+			template: util.Template{DestinationPath: "/app/secrets.json", Format: util.TemplateFormatJSON, Secrets: secrets, TemplateContent: "{{ .Value }}"},
+			wantErr:  "can't be combined with source-path, template-content or base64-template-content",
+		},
+		{
+			name:     "format with source path",
+			template: util.Template{DestinationPath: "/app/secrets.json", Format: util.TemplateFormatJSON, Secrets: secrets, SourcePath: "/templates/app.tmpl"},
+			wantErr:  "can't be combined with source-path, template-content or base64-template-content",
+		},
+		{
+			name:     "format without secrets",
+			template: util.Template{DestinationPath: "/app/secrets.json", Format: util.TemplateFormatJSON},
+			wantErr:  "secrets.project-id and secrets.environment are required",
+		},
+		{
+			name:     "unsupported format",
+			template: util.Template{DestinationPath: "/app/secrets.toml", Format: "toml", Secrets: secrets},
+			wantErr:  "template format toml not supported",
+		},
+		{
+			name:     "env template in json",
+			template: util.Template{DestinationPath: "/app/secrets.json", Format: util.TemplateFormatJSON, Secrets: secrets, Env: true},
+			wantErr:  "requires format dotenv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTemplateFormat(tt.template)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// templatesFromAnnotations returns the templates declared on the pod with the inject template annotations, sorted by name.
+// the destination is optional and defaulted like the destination of config map templates
+func templatesFromAnnotations(pod *corev1.Pod) ([]util.Template, error) {
+	var names []string
+	for annotation := range pod.Annotations {
+		if name, found := strings.CutPrefix(annotation, util.AnnotationInjectTemplatePrefix); found {
+			names = append(names, name)
+		}
+	}
+
+	for annotation := range pod.Annotations {
+		if name, found := strings.CutPrefix(annotation, util.AnnotationInjectDestinationPrefix); found && !slices.Contains(names, name) {
+			return nil, fmt.Errorf("%s is set without %s", annotation, util.AnnotationInjectTemplatePrefix+name)
+		}
+	}
+
+	// map iteration order is random, the order of the templates decides the default destination paths
+	slices.Sort(names)
+
+	templates := []util.Template{}
+	for _, name := range names {
+		templateAnnotation := util.AnnotationInjectTemplatePrefix + name
+		if name == "" {
+			return nil, fmt.Errorf("%s must be followed by a template name, e.g. %sdb", util.AnnotationInjectTemplatePrefix, util.AnnotationInjectTemplatePrefix)
+		}
+		if strings.TrimSpace(pod.Annotations[templateAnnotation]) == "" {
+			return nil, fmt.Errorf("%s must not be empty", templateAnnotation)
+		}
+
+		templates = append(templates, util.Template{
+			TemplateContent: pod.Annotations[templateAnnotation],
+			DestinationPath: pod.Annotations[util.AnnotationInjectDestinationPrefix+name],
+		})
+	}
+
+	return templates, nil
+}
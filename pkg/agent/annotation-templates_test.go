@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTemplatesFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []util.Template
+		wantErr     string
+	}{
+		{
+			name:        "no annotations",
+			annotations: map[string]string{util.InjectAnnotation: "true"},
+			want:        []util.Template{},
+		},
+		{
+			name: "sorted by name",
+			annotations: map[string]string{
+				util.AnnotationInjectTemplatePrefix + "db":      "{{ .db }}",
+				util.AnnotationInjectTemplatePrefix + "api":     "{{ .api }}",
+				util.AnnotationInjectDestinationPrefix + "db":   "/app/db.env",
+				util.AnnotationInjectDestinationPrefix + "api":  "/app/api.env",
+				util.AnnotationInjectTemplatePrefix + "default": "{{ .default }}",
+			},
+			want: []util.Template{
+				{TemplateContent: "{{ .api }}", DestinationPath: "/app/api.env"},
+				{TemplateContent: "{{ .db }}", DestinationPath: "/app/db.env"},
+				{TemplateContent: "{{ .default }}"},
+			},
+		},
+		{
+			name:        "destination without template",
+			annotations: map[string]string{util.AnnotationInjectDestinationPrefix + "db": "/app/db.env"},
+			wantErr:     "is set without " + util.AnnotationInjectTemplatePrefix + "db",
+		},
+		{
+			name:        "template without name",
+			annotations: map[string]string{util.AnnotationInjectTemplatePrefix: "{{ .db }}"},
+			wantErr:     "must be followed by a template name",
+		},
+		{
+			name:        "empty template",
+			annotations: map[string]string{util.AnnotationInjectTemplatePrefix + "db": " \n"},
+			wantErr:     "must not be empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+
+			got, err := templatesFromAnnotations(pod)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// envTemplatePaths returns the destination paths of the templates that are loaded into the app environment
+func (a *Agent) envTemplatePaths() []string {
+	var paths []string
+	for _, template := range a.configMap.Templates {
+		if template.Env {
+			paths = append(paths, template.DestinationPath)
+		}
+	}
+	return paths
+}
+
+// envWrappedContainers returns the indexes of the app containers whose entrypoint is wrapped
+func (a *Agent) envWrappedContainers() []int {
+	var containerNames []string
+	if a.pod.Annotations[util.AnnotationEnvContainers] != "" {
+		for _, name := range strings.Split(a.pod.Annotations[util.AnnotationEnvContainers], ",") {
+			containerNames = append(containerNames, strings.TrimSpace(name))
+		}
+	}
+
+	var indexes []int
+	for i, container := range a.pod.Spec.Containers {
+		if len(containerNames) == 0 || slices.Contains(containerNames, container.Name) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// envWrappedEntrypoint returns the original entrypoint of the container, which the wrapper executes after loading the env templates.
+// if the container doesn't set a command, the image entrypoint must be provided through an annotation since we don't read image configs.
+func (a *Agent) envWrappedEntrypoint(container corev1.Container) ([]string, error) {
+	if len(container.Command) > 0 {
+		return slices.Concat(container.Command, container.Args), nil
+	}
+
+	annotation := util.AnnotationEnvCommandPrefix + container.Name
+	if a.pod.Annotations[annotation] == "" {
+		return nil, fmt.Errorf("container %s has no command, please set the image entrypoint with the %s annotation (e.g. [\"/docker-entrypoint.sh\"])", container.Name, annotation)
+	}
+
+	var entrypoint []string
+	if err := json.Unmarshal([]byte(a.pod.Annotations[annotation]), &entrypoint); err != nil {
+		return nil, fmt.Errorf("failed to parse %s annotation, must be a JSON array of strings: %w", annotation, err)
+	}
+
+	if len(entrypoint) == 0 {
+		return nil, fmt.Errorf("%s annotation must not be empty", annotation)
+	}
+
+	return slices.Concat(entrypoint, container.Args), nil
+}
+
+func (a *Agent) validateEnvInjection() error {
+	if len(a.envTemplatePaths()) == 0 {
+		return nil
+	}
+
+	if a.isWindows {
+		return fmt.Errorf("env templates are not supported on windows pods")
+	}
+
+	if util.GetInjectorImage() == "" {
+		return fmt.Errorf("env templates require the %s env var to be set on the injector", util.EnvInjectorImage)
+	}
+
+	for _, template := range a.configMap.Templates {
+		if template.Env && template.KubernetesSecret != nil && template.KubernetesSecret.Only {
+			return fmt.Errorf("template %s is only written to a kubernetes secret and cannot be loaded as env", template.DestinationPath)
+		}
+	}
+
+	if a.pod.Annotations[util.AnnotationEnvContainers] != "" {
+		for _, name := range strings.Split(a.pod.Annotations[util.AnnotationEnvContainers], ",") {
+			if !slices.ContainsFunc(a.pod.Spec.Containers, func(container corev1.Container) bool {
+				return container.Name == strings.TrimSpace(name)
+			}) {
+				return fmt.Errorf("container %s from %s not found in pod", strings.TrimSpace(name), util.AnnotationEnvContainers)
+			}
+		}
+	}
+
+	for _, i := range a.envWrappedContainers() {
+		if _, err := a.envWrappedEntrypoint(a.pod.Spec.Containers[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// envWrapperPatches mounts the wrapper into the app containers and replaces their command with the wrapper
+func (a *Agent) envWrapperPatches() (jsonpatch.Patch, error) {
+	envTemplatePaths := a.envTemplatePaths()
+	if len(envTemplatePaths) == 0 {
+		return nil, nil
+	}
+
+	// the sidecar agent has as long to render the templates as the init agent would have
+	timeoutSeconds, err := util.GetInitTimeoutSeconds(a.configMap, a.pod.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	command := []string{fmt.Sprintf("%s/%s", util.EnvWrapperMountPath, util.EnvWrapperBinaryName), "env-exec", "--timeout", fmt.Sprintf("%ds", timeoutSeconds)}
+	for _, envTemplatePath := range envTemplatePaths {
+		command = append(command, "--env-file", envTemplatePath)
+	}
+	command = append(command, "--")
+
+	var patches jsonpatch.Patch
+	for _, i := range a.envWrappedContainers() {
+		container := a.pod.Spec.Containers[i]
+
+		entrypoint, err := a.envWrappedEntrypoint(container)
+		if err != nil {
+			return nil, err
+		}
+
+		// the secret volume mounts are added in the same patch, so we check against them as well
+		existingMounts := slices.Concat(container.VolumeMounts, a.AppContainerVolumeMounts(container.VolumeMounts))
+		patches = append(patches, addVolumeMounts(
+			existingMounts,
+			[]corev1.VolumeMount{{Name: util.EnvWrapperVolumeName, MountPath: util.EnvWrapperMountPath, ReadOnly: true}},
+			fmt.Sprintf("/spec/containers/%d/volumeMounts", i))...)
+
+		patches = append(patches,
+			AddOp(fmt.Sprintf("/spec/containers/%d/command", i), command),
+			AddOp(fmt.Sprintf("/spec/containers/%d/args", i), entrypoint))
+	}
+
+	return patches, nil
+}
+
+// ContainerEnvWrapperInit copies the injector binary, which doubles as the env wrapper, into a volume shared with the app containers
+func (a *Agent) ContainerEnvWrapperInit() (corev1.Container, error) {
+	injectorImage := util.GetInjectorImage()
+	if injectorImage == "" {
+		return corev1.Container{}, fmt.Errorf("%s env var is required for env templates", util.EnvInjectorImage)
+	}
+
+	resources, err := a.ResourceRequirements(util.ContainerRoleInit)
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("failed to get resource requirements: %w", err)
+	}
+
+	securityContext, err := a.SecurityContext()
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("failed to get security context: %w", err)
+	}
+
+	newContainer := corev1.Container{
+		Name:      util.EnvWrapperInitContainerName,
+		Image:     injectorImage,
+		Resources: resources,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      util.EnvWrapperVolumeName,
+				MountPath: util.EnvWrapperMountPath,
+			},
+		},
+		Command: []string{"cp", util.InjectorBinaryPath, fmt.Sprintf("%s/%s", util.EnvWrapperMountPath, util.EnvWrapperBinaryName)},
+	}
+
+	if securityContext != nil {
+		newContainer.SecurityContext = securityContext
+	}
+
+	return newContainer, nil
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnvWrapperPatchesTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		initTimeout string
+		want        string
+	}{
+		{name: "default", want: "180s"},
+		{name: "config map", initTimeout: "5m", want: "300s"},
+		{name: "annotation", annotations: map[string]string{util.AnnotationInitTimeout: "60"}, initTimeout: "5m", want: "60s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps", Annotations: tt.annotations},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:         "app",
+							Command:      []string{"/app/server"},
+							VolumeMounts: []corev1.VolumeMount{{Name: "token", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount"}},
+						},
+					},
+				},
+			}
+
+			configMap := &util.ConfigMap{Templates: []util.Template{{DestinationPath: "/shared/.env", TemplateContent: "A=1", Env: true}}}
+			configMap.Infisical.InitTimeout = tt.initTimeout
+
+			agent, err := NewAgent(pod, configMap, util.PodPlatform{OS: util.OSLinux}, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			patches, err := agent.envWrapperPatches()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			command := commandPatchValue(t, patches)
+			timeoutIndex := slices.Index(command, "--timeout")
+			if timeoutIndex == -1 || timeoutIndex+1 >= len(command) || command[timeoutIndex+1] != tt.want {
+				t.Errorf("got command %q, want --timeout %s", command, tt.want)
+			}
+		})
+	}
+}
+
+// commandPatchValue returns the command the patches set on the first container
+func commandPatchValue(t *testing.T, patches jsonpatch.Patch) []string {
+	t.Helper()
+
+	for _, operation := range patches {
+		path, err := operation.Path()
+		if err != nil || path != "/spec/containers/0/command" {
+			continue
+		}
+
+		var command []string
+		if err := json.Unmarshal(*operation["value"], &command); err != nil {
+			t.Fatalf("failed to parse command patch: %v", err)
+		}
+		return command
+	}
+
+	t.Fatalf("no command patch in %v", patches)
+	return nil
+}
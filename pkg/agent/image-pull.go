@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ImagePullSecrets returns the pull secrets configured for the registries of the injected images that the pod doesn't list yet
+func (a *Agent) ImagePullSecrets() ([]string, error) {
+	images := []string{a.agentImage}
+
+	// the secret sync and env wrapper containers run the injector image
+	if len(a.SecretSyncTargets()) > 0 || len(a.envTemplatePaths()) > 0 {
+		images = append(images, util.GetInjectorImage())
+	}
+
+	var secrets []string
+	for _, image := range images {
+		if image == "" {
+			continue
+		}
+
+		for _, secret := range util.GetImagePullSecrets(image) {
+			alreadyListed := slices.ContainsFunc(a.pod.Spec.ImagePullSecrets, func(reference corev1.LocalObjectReference) bool {
+				return reference.Name == secret
+			})
+			if !alreadyListed && !slices.Contains(secrets, secret) {
+				secrets = append(secrets, secret)
+			}
+		}
+	}
+
+	return secrets, nil
+}
+
+// imagePullPolicy returns the pull policy of the agent containers, the annotation takes precedence over the policy configured on the injector.
+// empty if neither is set, so kubernetes picks the default for the image tag
+func (a *Agent) imagePullPolicy() (corev1.PullPolicy, error) {
+	pullPolicy := a.pod.Annotations[util.AnnotationAgentImagePullPolicy]
+	if pullPolicy == "" {
+		pullPolicy = string(util.GetAgentImagePullPolicy())
+	}
+
+	switch corev1.PullPolicy(pullPolicy) {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+		return corev1.PullPolicy(pullPolicy), nil
+	}
+
+	return "", fmt.Errorf("invalid image pull policy %s. please use %s, %s or %s", pullPolicy, corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever)
+}
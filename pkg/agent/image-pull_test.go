@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestImagePullPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		injectorPolicy string
+		annotation     string
+		want           corev1.PullPolicy
+		wantErr        string
+	}{
+		{name: "kubernetes default", want: ""},
+		{name: "injector", injectorPolicy: "IfNotPresent", want: corev1.PullIfNotPresent},
+		{name: "annotation overrides the injector", injectorPolicy: "IfNotPresent", annotation: "Always", want: corev1.PullAlways},
+		{name: "invalid annotation", annotation: "Sometimes", wantErr: "invalid image pull policy Sometimes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// runs after t.Setenv restored the env, so later tests don't see the policy
+			t.Cleanup(func() { _ = util.LoadSettings() })
+			t.Setenv(util.EnvAgentImagePullPolicy, tt.injectorPolicy)
+			if err := util.LoadSettings(); err != nil {
+				t.Fatalf("failed to load settings: %v", err)
+			}
+
+			annotations := map[string]string{}
+			if tt.annotation != "" {
+				annotations[util.AnnotationAgentImagePullPolicy] = tt.annotation
+			}
+
+			got, err := newTestAgent(t, annotations, util.OSLinux, "/shared/secrets").imagePullPolicy()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,13 +19,26 @@ func (a *Agent) ContainerInitSidecar() (corev1.Container, error) {
 	}
 
 	volumeMounts = append(volumeMounts, a.ContainerVolumeMounts(volumeMounts)...)
+	volumeMounts = append(volumeMounts, a.tlsVolumeMounts()...)
+	volumeMounts = append(volumeMounts, a.agentLogVolumeMount())
 
 	script, envVars, err := util.BuildAgentScript(*a.configMap, true, a.isWindows, a.injectMode, a.cachingEnabled, a.pod.Annotations)
 	if err != nil {
 		return corev1.Container{}, fmt.Errorf("failed to build agent script: %w", err)
 	}
 
-	resources, err := a.ResourceRequirements()
+	proxyEnvVars, err := a.proxyEnvVars()
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	envVars = append(envVars, proxyEnvVars...)
+
+	pullPolicy, err := a.imagePullPolicy()
+	if err != nil {
+		return corev1.Container{}, err
+	}
+
+	resources, err := a.ResourceRequirements(util.ContainerRoleInit)
 	if err != nil {
 		return corev1.Container{}, fmt.Errorf("failed to get resource requirements: %w", err)
 	}
@@ -41,13 +54,14 @@ func (a *Agent) ContainerInitSidecar() (corev1.Container, error) {
 	}
 
 	newContainer := corev1.Container{
-		Name:         util.InitContainerName,
-		Image:        a.agentImage,
-		Resources:    resources,
-		VolumeMounts: volumeMounts,
-		Command:      command,
-		Env:          envVars,
-		Args:         []string{script},
+		Name:            util.InitContainerName,
+		Image:           a.agentImage,
+		ImagePullPolicy: pullPolicy,
+		Resources:       resources,
+		VolumeMounts:    volumeMounts,
+		Command:         command,
+		Env:             envVars,
+		Args:            []string{script},
 	}
 
 	if securityContext != nil {
@@ -57,3 +71,17 @@ func (a *Agent) ContainerInitSidecar() (corev1.Container, error) {
 	return newContainer, nil
 
 }
+
+// agentLogVolumeMount returns the mount for the output of the init agent, which is summarized in the termination message if it fails.
+// the volume isn't mounted into the app containers, as the output can contain details of the secrets being fetched
+func (a *Agent) agentLogVolumeMount() corev1.VolumeMount {
+	mountPath := util.LinuxContainerAgentLogMountPath
+	if a.isWindows {
+		mountPath = util.WindowsContainerAgentLogMountPath
+	}
+
+	return corev1.VolumeMount{
+		Name:      util.ContainerAgentLogVolumeName,
+		MountPath: mountPath,
+	}
+}
@@ -57,6 +57,10 @@ func addVolumes(target []corev1.Volume, volumes []corev1.Volume, base string) js
 func addVolumeMounts(target []corev1.VolumeMount, mounts []corev1.VolumeMount, base string) jsonpatch.Patch {
 	return addResourcesWithPath(target, mounts, base)
 }
+func addImagePullSecrets(target []corev1.LocalObjectReference, secrets []corev1.LocalObjectReference, base string) jsonpatch.Patch {
+	return addResourcesWithPath(target, secrets, base)
+}
+
 func removeContainers(path string) jsonpatch.Patch {
 	return []jsonpatch.Operation{RemoveOp(path)}
 }
@@ -87,3 +91,26 @@ func updatePodAnnotations(target map[string]string, annotations map[string]strin
 
 	return result
 }
+
+func updatePodLabels(target map[string]string, labels map[string]string) jsonpatch.Patch {
+	var result jsonpatch.Patch
+	if len(target) == 0 {
+		return []jsonpatch.Operation{AddOp("/metadata/labels", labels)}
+	}
+
+	for key, value := range labels {
+		escapedKey := strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+
+		result = append(result, AddOp("/metadata/labels/"+escapedKey, value))
+	}
+
+	return result
+}
+
+func updatePodFSGroup(target *corev1.PodSecurityContext, fsGroup int64) jsonpatch.Patch {
+	if target == nil {
+		return []jsonpatch.Operation{AddOp("/spec/securityContext", corev1.PodSecurityContext{FSGroup: &fsGroup})}
+	}
+
+	return []jsonpatch.Operation{AddOp("/spec/securityContext/fsGroup", fsGroup)}
+}
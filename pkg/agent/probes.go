@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type Probes struct {
+	Startup  *corev1.Probe
+	Liveness *corev1.Probe
+}
+
+// Probes returns the probes of the agent sidecar, or nil if probes aren't enabled on the pod.
+// the startup probe passes once every template has been rendered, and the liveness probe fails if the access token file goes missing or stale.
+func (a *Agent) Probes() (*Probes, error) {
+	probesEnabled, err := util.ParseStringToBool(a.pod.Annotations[util.AnnotationProbesEnabled], false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse probes enabled annotation: %w", err)
+	}
+
+	if !probesEnabled {
+		return nil, nil
+	}
+
+	startupPeriodSeconds, err := parseProbeAnnotation(a.pod.Annotations, util.AnnotationStartupProbePeriodSeconds, util.DefaultStartupProbePeriodSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	startupFailureThreshold, err := parseProbeAnnotation(a.pod.Annotations, util.AnnotationStartupProbeFailureThreshold, util.DefaultStartupProbeFailureThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	livenessPeriodSeconds, err := parseProbeAnnotation(a.pod.Annotations, util.AnnotationLivenessProbePeriodSeconds, util.DefaultLivenessProbePeriodSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	livenessFailureThreshold, err := parseProbeAnnotation(a.pod.Annotations, util.AnnotationLivenessProbeFailureThreshold, util.DefaultLivenessProbeFailureThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	// by default we only check that the token exists, since how often it's renewed depends on the identity's token ttl
+	tokenMaxAgeSeconds := 0
+	if a.pod.Annotations[util.AnnotationLivenessTokenMaxAge] != "" {
+		tokenMaxAgeSeconds, err = util.ParseDurationSeconds(a.pod.Annotations[util.AnnotationLivenessTokenMaxAge])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse liveness token max age annotation: %w", err)
+		}
+	}
+
+	timeoutSeconds := util.ProbeTimeoutSeconds
+	if a.isWindows {
+		timeoutSeconds = util.WindowsProbeTimeoutSeconds
+	}
+
+	var destinationPaths []string
+	for _, template := range a.configMap.Templates {
+		destinationPaths = append(destinationPaths, template.DestinationPath)
+	}
+
+	return &Probes{
+		Startup: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				Exec: &corev1.ExecAction{
+					Command: a.filesExistCommand(destinationPaths),
+				},
+			},
+			PeriodSeconds:    int32(startupPeriodSeconds),
+			FailureThreshold: int32(startupFailureThreshold),
+			TimeoutSeconds:   int32(timeoutSeconds),
+		},
+		Liveness: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				Exec: &corev1.ExecAction{
+					Command: a.tokenFreshCommand(tokenMaxAgeSeconds),
+				},
+			},
+			PeriodSeconds:    int32(livenessPeriodSeconds),
+			FailureThreshold: int32(livenessFailureThreshold),
+			TimeoutSeconds:   int32(timeoutSeconds),
+		},
+	}, nil
+}
+
+func parseProbeAnnotation(annotations map[string]string, annotation string, defaultValue int) (int, error) {
+	value, err := util.ParseStringToInt(annotations[annotation], defaultValue)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s annotation: %w", annotation, err)
+	}
+
+	if value == 0 {
+		return 0, fmt.Errorf("invalid %s annotation, must be greater than zero", annotation)
+	}
+
+	return value, nil
+}
+
+func (a *Agent) accessTokenPath() string {
+	if a.isWindows {
+		return util.WindowsContainerWorkDirVolumeMountPath + "\\identity-access-token"
+	}
+	return util.LinuxContainerWorkDirVolumeMountPath + "/identity-access-token"
+}
+
+// filesExistCommand returns a probe command that succeeds if all files exist. templates may render to an empty file, e.g. a folder without secrets
+func (a *Agent) filesExistCommand(paths []string) []string {
+	var checks []string
+
+	if a.isWindows {
+		for _, p := range paths {
+			checks = append(checks, fmt.Sprintf("(Test-Path -LiteralPath %s -PathType Leaf)", util.PowerShellQuote(p)))
+		}
+		return []string{"pwsh.exe", "-Command", fmt.Sprintf("if (%s) { exit 0 } else { exit 1 }", strings.Join(checks, " -and "))}
+	}
+
+	for _, p := range paths {
+		checks = append(checks, fmt.Sprintf("test -f %s", util.ShellQuote(p)))
+	}
+	return []string{"/bin/sh", "-c", strings.Join(checks, " && ")}
+}
+
+// tokenFreshCommand returns a probe command that succeeds if the access token exists and, if maxAgeSeconds is set, was written within that time
+func (a *Agent) tokenFreshCommand(maxAgeSeconds int) []string {
+	tokenPath := a.accessTokenPath()
+
+	if a.isWindows {
+		script := fmt.Sprintf("$token = Get-Item -LiteralPath %s -ErrorAction SilentlyContinue; if ($null -eq $token -or $token.Length -eq 0) { exit 1 }", util.PowerShellQuote(tokenPath))
+		if maxAgeSeconds > 0 {
+			script += fmt.Sprintf("; if (((Get-Date) - $token.LastWriteTime).TotalSeconds -gt %d) { exit 1 }", maxAgeSeconds)
+		}
+		return []string{"pwsh.exe", "-Command", script + "; exit 0"}
+	}
+
+	script := fmt.Sprintf("test -s %s", util.ShellQuote(tokenPath))
+	if maxAgeSeconds > 0 {
+		script += fmt.Sprintf(" && [ $(( $(date +%%s) - $(stat -c %%Y %s) )) -le %d ]", util.ShellQuote(tokenPath), maxAgeSeconds)
+	}
+	return []string{"/bin/sh", "-c", script}
+}
+
+// waitForFilesCommand returns a command that blocks until all files exist, and fails after timeoutSeconds
+func (a *Agent) waitForFilesCommand(paths []string, timeoutSeconds int) []string {
+	var checks []string
+
+	if a.isWindows {
+		for _, p := range paths {
+			checks = append(checks, fmt.Sprintf("(Test-Path -LiteralPath %s -PathType Leaf)", util.PowerShellQuote(p)))
+		}
+		script := fmt.Sprintf("$deadline = (Get-Date).AddSeconds(%d); while (-not (%s)) { if ((Get-Date) -gt $deadline) { Write-Error 'Timed out waiting for secrets'; exit 1 }; Start-Sleep -Seconds 1 }; exit 0", timeoutSeconds, strings.Join(checks, " -and "))
+		return []string{"pwsh.exe", "-Command", script}
+	}
+
+	for _, p := range paths {
+		checks = append(checks, fmt.Sprintf("test -f %s", util.ShellQuote(p)))
+	}
+	script := fmt.Sprintf("deadline=$(( $(date +%%s) + %d )); until %s; do if [ $(date +%%s) -ge $deadline ]; then echo 'Timed out waiting for secrets'; exit 1; fi; sleep 1; done", timeoutSeconds, strings.Join(checks, " && "))
+	return []string{"/bin/sh", "-c", script}
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestAgent(t *testing.T, annotations map[string]string, podOS string, destinationPaths ...string) *Agent {
+	t.Helper()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps", Annotations: annotations},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:         "app",
+					VolumeMounts: []corev1.VolumeMount{{Name: "token", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount"}},
+				},
+			},
+		},
+	}
+
+	configMap := &util.ConfigMap{}
+	for _, destinationPath := range destinationPaths {
+		configMap.Templates = append(configMap.Templates, util.Template{DestinationPath: destinationPath, TemplateContent: "{{ .Value }}"})
+	}
+
+	agent, err := NewAgent(pod, configMap, util.PodPlatform{OS: podOS}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return agent
+}
+
+func TestProbes(t *testing.T) {
+	enabled := map[string]string{util.AnnotationProbesEnabled: "true"}
+
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		os              string
+		wantNil         bool
+		wantTimeout     int32
+		wantStartupExec string
+		wantErr         string
+	}{
+		{
+			name:    "disabled",
+			os:      util.OSLinux,
+			wantNil: true,
+		},
+		{
+			name:            "linux",
+			annotations:     enabled,
+			os:              util.OSLinux,
+			wantTimeout:     util.ProbeTimeoutSeconds,
+			wantStartupExec: "test -f '/shared/secrets'",
+		},
+		{
+			name:            "windows",
+			annotations:     enabled,
+			os:              util.OSWindows,
+			wantTimeout:     util.WindowsProbeTimeoutSeconds,
+			wantStartupExec: "Test-Path -LiteralPath '/shared/secrets' -PathType Leaf",
+		},
+		{
+			name:        "zero period",
+			annotations: map[string]string{util.AnnotationProbesEnabled: "true", util.AnnotationLivenessProbePeriodSeconds: "0"},
+			os:          util.OSLinux,
+			wantErr:     "must be greater than zero",
+		},
+		{
+			name:        "invalid token max age",
+			annotations: map[string]string{util.AnnotationProbesEnabled: "true", util.AnnotationLivenessTokenMaxAge: "soon"},
+			os:          util.OSLinux,
+			wantErr:     "failed to parse liveness token max age annotation",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probes, err := newTestAgent(t, tt.annotations, tt.os, "/shared/secrets").Probes()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.wantNil {
+				if probes != nil {
+					t.Errorf("got probes %+v, want none", probes)
+				}
+				return
+			}
+
+			for name, probe := range map[string]*corev1.Probe{"startup": probes.Startup, "liveness": probes.Liveness} {
+				if probe.TimeoutSeconds != tt.wantTimeout {
+					t.Errorf("%s probe: got timeout %d, want %d", name, probe.TimeoutSeconds, tt.wantTimeout)
+				}
+			}
+
+			if command := strings.Join(probes.Startup.Exec.Command, " "); !strings.Contains(command, tt.wantStartupExec) {
+				t.Errorf("got startup command %q, want it to contain %q", command, tt.wantStartupExec)
+			}
+		})
+	}
+}
+
+func TestProbeCommands(t *testing.T) {
+	dir := t.TempDir()
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	rendered := filepath.Join(dir, "it's rendered")
+	if err := os.WriteFile(rendered, []byte("A=1\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  bool
+	}{
+		{name: "rendered", paths: []string{rendered}, want: true},
+		{name: "rendered empty", paths: []string{empty, rendered}, want: true},
+		{name: "missing", paths: []string{rendered, missing}, want: false},
+		{name: "directory", paths: []string{dir}, want: false},
+	}
+
+	agent := newTestAgent(t, nil, util.OSLinux, "/shared/secrets")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command := agent.filesExistCommand(tt.paths)
+			if got := exec.Command(command[0], command[1:]...).Run() == nil; got != tt.want {
+				t.Errorf("probe %q: got success %v, want %v", command, got, tt.want)
+			}
+
+			command = agent.waitForFilesCommand(tt.paths, 0)
+			if got := exec.Command(command[0], command[1:]...).Run() == nil; got != tt.want {
+				t.Errorf("wait %q: got success %v, want %v", command, got, tt.want)
+			}
+		})
+	}
+}
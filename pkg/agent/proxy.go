@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// proxyEnvVars returns the proxy env vars of the agent containers. the settings are resolved per variable in order of:
+// the pod annotations, the proxy env vars of the primary app container if inherited, the config map, and the injector env
+func (a *Agent) proxyEnvVars() ([]corev1.EnvVar, error) {
+	injectorProxy := util.GetAgentProxy()
+	values := map[string]string{
+		"HTTP_PROXY":  injectorProxy.HTTPProxy,
+		"HTTPS_PROXY": injectorProxy.HTTPSProxy,
+		"NO_PROXY":    injectorProxy.NoProxy,
+	}
+
+	inherit := false
+	if proxy := a.configMap.Infisical.Proxy; proxy != nil {
+		setIfNotEmpty(values, "HTTP_PROXY", proxy.HTTPProxy)
+		setIfNotEmpty(values, "HTTPS_PROXY", proxy.HTTPSProxy)
+		setIfNotEmpty(values, "NO_PROXY", proxy.NoProxy)
+		inherit = proxy.InheritFromApp
+	}
+
+	inherit, err := util.ParseStringToBool(a.pod.Annotations[util.AnnotationProxyInherit], inherit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy inherit annotation: %w", err)
+	}
+
+	if container := a.primaryContainer(); inherit && container != nil {
+		// env vars from secrets or config maps aren't resolved at admission time, so only literal values are inherited
+		for _, envVar := range container.Env {
+			name := strings.ToUpper(envVar.Name)
+			if _, isProxyEnvVar := values[name]; isProxyEnvVar && envVar.ValueFrom == nil {
+				setIfNotEmpty(values, name, envVar.Value)
+			}
+		}
+	}
+
+	setIfNotEmpty(values, "HTTP_PROXY", a.pod.Annotations[util.AnnotationHTTPProxy])
+	setIfNotEmpty(values, "HTTPS_PROXY", a.pod.Annotations[util.AnnotationHTTPSProxy])
+	setIfNotEmpty(values, "NO_PROXY", a.pod.Annotations[util.AnnotationNoProxy])
+
+	var envVars []corev1.EnvVar
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		if values[name] != "" {
+			envVars = append(envVars, corev1.EnvVar{Name: name, Value: values[name]})
+		}
+	}
+
+	return envVars, nil
+}
+
+func setIfNotEmpty(values map[string]string, key string, value string) {
+	if value != "" {
+		values[key] = value
+	}
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestProxyEnvVars(t *testing.T) {
+	tests := []struct {
+		name          string
+		injectorProxy string
+		configProxy   *util.ProxyConfig
+		appEnv        []corev1.EnvVar
+		annotations   map[string]string
+		want          []corev1.EnvVar
+	}{
+		{
+			name: "no proxy",
+		},
+		{
+			name:          "injector",
+			injectorProxy: "http://injector:3128",
+			want:          []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://injector:3128"}},
+		},
+		{
+			name:          "config map overrides the injector",
+			injectorProxy: "http://injector:3128",
+			configProxy:   &util.ProxyConfig{HTTPProxy: "http://config:3128", NoProxy: ".svc"},
+			want:          []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://config:3128"}, {Name: "NO_PROXY", Value: ".svc"}},
+		},
+		{
+			name:          "inherited from the app",
+			injectorProxy: "http://injector:3128",
+			configProxy:   &util.ProxyConfig{InheritFromApp: true},
+			appEnv: []corev1.EnvVar{
+				{Name: "http_proxy", Value: "http://app:3128"},
+				{Name: "HTTPS_PROXY", ValueFrom: &corev1.EnvVarSource{}},
+			},
+			want: []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://app:3128"}},
+		},
+		{
+			name:          "annotations override everything",
+			injectorProxy: "http://injector:3128",
+			configProxy:   &util.ProxyConfig{HTTPProxy: "http://config:3128"},
+			annotations:   map[string]string{util.AnnotationHTTPProxy: "http://pod:3128"},
+			want:          []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://pod:3128"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// runs after t.Setenv restored the env, so later tests don't see the proxy
+			t.Cleanup(func() { _ = util.LoadSettings() })
+			t.Setenv(util.EnvAgentHTTPProxy, tt.injectorProxy)
+			t.Setenv(util.EnvAgentHTTPSProxy, "")
+			t.Setenv(util.EnvAgentNoProxy, "")
+			if err := util.LoadSettings(); err != nil {
+				t.Fatalf("failed to load settings: %v", err)
+			}
+
+			agent := newTestAgent(t, tt.annotations, util.OSLinux, "/shared/secrets")
+			agent.configMap.Infisical.Proxy = tt.configProxy
+			agent.pod.Spec.Containers[0].Env = tt.appEnv
+
+			got, err := agent.proxyEnvVars()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
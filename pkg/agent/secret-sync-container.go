@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	"github.com/Infisical/infisical-agent-injector/pkg/util/path"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SecretSyncTargets returns the templates that should be written to a kubernetes secret
+func (a *Agent) SecretSyncTargets() []util.SecretSyncTarget {
+	var targets []util.SecretSyncTarget
+
+	for _, template := range a.configMap.Templates {
+		if template.KubernetesSecret == nil {
+			continue
+		}
+
+		format := template.KubernetesSecret.Format
+		if format == "" {
+			format = util.KubernetesSecretFormatFile
+		}
+
+		key := template.KubernetesSecret.Key
+		if key == "" && format == util.KubernetesSecretFormatFile {
+			key = path.Base(template.DestinationPath, a.isWindows)
+		}
+
+		targets = append(targets, util.SecretSyncTarget{
+			SecretName: template.KubernetesSecret.Name,
+			Key:        key,
+			Format:     format,
+			Path:       template.DestinationPath,
+		})
+	}
+
+	return targets
+}
+
+// ContainerSecretSync runs the injector image to copy the rendered templates into kubernetes secrets.
+// when once is true, it runs as an init container after the agent init container.
+func (a *Agent) ContainerSecretSync(once bool) (corev1.Container, error) {
+	injectorImage := util.GetInjectorImage()
+	if injectorImage == "" {
+		return corev1.Container{}, fmt.Errorf("%s env var is required for kubernetes secret sinks", util.EnvInjectorImage)
+	}
+
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      a.serviceAccountTokenVolume.Name,
+			MountPath: a.serviceAccountTokenVolume.MountPath,
+			ReadOnly:  true,
+		},
+	}
+	volumeMounts = append(volumeMounts, a.ContainerVolumeMounts(volumeMounts)...)
+
+	targets, err := json.Marshal(a.SecretSyncTargets())
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("failed to marshal secret sync targets: %w", err)
+	}
+
+	role := util.ContainerRoleSidecar
+	if once {
+		role = util.ContainerRoleInit
+	}
+
+	resources, err := a.ResourceRequirements(role)
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("failed to get resource requirements: %w", err)
+	}
+
+	securityContext, err := a.SecurityContext()
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("failed to get security context: %w", err)
+	}
+
+	name := util.SecretSyncContainerName
+	args := []string{"sync-secret", "--interval", strconv.Itoa(util.DefaultSecretSyncIntervalSecs) + "s"}
+	if once {
+		name = util.SecretSyncInitContainerName
+		args = []string{"sync-secret", "--once"}
+	}
+
+	newContainer := corev1.Container{
+		Name:         name,
+		Image:        injectorImage,
+		Resources:    resources,
+		VolumeMounts: volumeMounts,
+		Args:         args,
+		Env: []corev1.EnvVar{
+			{
+				Name:  util.EnvSecretSyncTargets,
+				Value: string(targets),
+			},
+			{
+				Name: "NAMESPACE",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+				},
+			},
+			{
+				Name: "POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+				},
+			},
+			{
+				Name: "POD_UID",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.uid"},
+				},
+			},
+		},
+	}
+
+	if securityContext != nil {
+		newContainer.SecurityContext = securityContext
+	}
+
+	return newContainer, nil
+}
@@ -20,42 +20,68 @@ func (a *Agent) ContainerSidecar() (corev1.Container, error) {
 
 	// This will add the secret volume mounts
 	volumeMounts = append(volumeMounts, a.ContainerVolumeMounts(volumeMounts)...)
+	volumeMounts = append(volumeMounts, a.tlsVolumeMounts()...)
 
 	script, envVars, err := util.BuildAgentScript(*a.configMap, false, a.isWindows, a.injectMode, a.cachingEnabled, a.pod.Annotations)
 	if err != nil {
 		return corev1.Container{}, fmt.Errorf("failed to build agent script: %w", err)
 	}
 
-	resources, err := a.ResourceRequirements()
+	proxyEnvVars, err := a.proxyEnvVars()
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	envVars = append(envVars, proxyEnvVars...)
+
+	pullPolicy, err := a.imagePullPolicy()
+	if err != nil {
+		return corev1.Container{}, err
+	}
+
+	resources, err := a.ResourceRequirements(util.ContainerRoleSidecar)
 	if err != nil {
 		return corev1.Container{}, fmt.Errorf("failed to get resource requirements: %w", err)
 	}
-	lifecycle := a.Lifecycle()
+	lifecycle, err := a.Lifecycle()
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("failed to get lifecycle: %w", err)
+	}
 
 	securityContext, err := a.SecurityContext()
 	if err != nil {
 		return corev1.Container{}, fmt.Errorf("failed to get security context: %w", err)
 	}
 
+	probes, err := a.Probes()
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("failed to get probes: %w", err)
+	}
+
 	command := []string{"/bin/sh", "-ec"}
 	if a.isWindows {
 		command = []string{"pwsh.exe", "-Command"}
 	}
 
 	newContainer := corev1.Container{
-		Name:         util.SidecarContainerName,
-		Image:        a.agentImage,
-		Resources:    resources,
-		VolumeMounts: volumeMounts,
-		Lifecycle:    &lifecycle,
-		Command:      command,
-		Args:         []string{script},
-		Env:          envVars,
+		Name:            util.SidecarContainerName,
+		Image:           a.agentImage,
+		ImagePullPolicy: pullPolicy,
+		Resources:       resources,
+		VolumeMounts:    volumeMounts,
+		Lifecycle:       &lifecycle,
+		Command:         command,
+		Args:            []string{script},
+		Env:             envVars,
 	}
 
 	if securityContext != nil {
 		newContainer.SecurityContext = securityContext
 	}
 
+	if probes != nil {
+		newContainer.StartupProbe = probes.Startup
+		newContainer.LivenessProbe = probes.Liveness
+	}
+
 	return newContainer, nil
 }
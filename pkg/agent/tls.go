@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func (a *Agent) validateTLSConfig() error {
+	tls := a.configMap.Infisical.TLS
+	if tls == nil {
+		return nil
+	}
+
+	if tls.CABundle != nil {
+		if (tls.CABundle.ConfigMap == "") == (tls.CABundle.Secret == "") {
+			return fmt.Errorf("tls ca-bundle must have exactly one of config-map or secret")
+		}
+	}
+
+	if tls.ClientCertificate != nil {
+		return fmt.Errorf("tls client-certificate is not supported, the agent can't authenticate to infisical with a client certificate")
+	}
+
+	return nil
+}
+
+// tlsVolume returns a projected volume with the CA bundle in its own directory, or nil if no CA bundle is set.
+// the CA directory must only hold certificates, as every file in it is trusted by the agent
+func (a *Agent) tlsVolume() *corev1.Volume {
+	tls := a.configMap.Infisical.TLS
+	if tls == nil || tls.CABundle == nil {
+		return nil
+	}
+
+	key := tls.CABundle.Key
+	if key == "" {
+		key = util.DefaultTLSCABundleKey
+	}
+	items := []corev1.KeyToPath{{Key: key, Path: util.TLSCABundleDir + "/" + util.DefaultTLSCABundleKey}}
+
+	var sources []corev1.VolumeProjection
+	if tls.CABundle.ConfigMap != "" {
+		sources = append(sources, corev1.VolumeProjection{
+			ConfigMap: &corev1.ConfigMapProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: tls.CABundle.ConfigMap},
+				Items:                items,
+			},
+		})
+	} else {
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: tls.CABundle.Secret},
+				Items:                items,
+			},
+		})
+	}
+
+	return &corev1.Volume{
+		Name: util.ContainerTLSVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: sources,
+			},
+		},
+	}
+}
+
+// tlsVolumeMounts returns the mount of the TLS volume, only the agent containers get it
+func (a *Agent) tlsVolumeMounts() []corev1.VolumeMount {
+	if a.tlsVolume() == nil {
+		return nil
+	}
+
+	mountPath := util.LinuxContainerTLSMountPath
+	if a.isWindows {
+		mountPath = util.WindowsContainerTLSMountPath
+	}
+
+	return []corev1.VolumeMount{{
+		Name:      util.ContainerTLSVolumeName,
+		MountPath: mountPath,
+		ReadOnly:  true,
+	}}
+}
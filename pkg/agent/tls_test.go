@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+)
+
+func TestValidateTLSConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		tls        *util.TLSConfig
+		wantVolume bool
+		wantErr    string
+	}{
+		{
+			name: "no tls",
+		},
+		{
+			name:       "ca bundle from a config map",
+			tls:        &util.TLSConfig{CABundle: &util.TLSCABundle{ConfigMap: "internal-ca"}},
+			wantVolume: true,
+		},
+		{
+			name:       "ca bundle from a secret",
+			tls:        &util.TLSConfig{CABundle: &util.TLSCABundle{Secret: "internal-ca", Key: "root.pem"}},
+			wantVolume: true,
+		},
+		{
+			name:    "ca bundle without a source",
+			tls:     &util.TLSConfig{CABundle: &util.TLSCABundle{}},
+			wantErr: "must have exactly one of config-map or secret",
+		},
+		{
+			name:    "ca bundle with both sources",
+			tls:     &util.TLSConfig{CABundle: &util.TLSCABundle{ConfigMap: "internal-ca", Secret: "internal-ca"}},
+			wantErr: "must have exactly one of config-map or secret",
+		},
+		{
+			name:    "client certificate",
+			tls:     &util.TLSConfig{ClientCertificate: map[string]interface{}{"secret": "client-tls"}},
+			wantErr: "tls client-certificate is not supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newTestAgent(t, nil, util.OSLinux, "/shared/secrets")
+			agent.configMap.Infisical.TLS = tt.tls
+
+			err := agent.validateTLSConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			volume := agent.tlsVolume()
+			if gotVolume := volume != nil; gotVolume != tt.wantVolume {
+				t.Fatalf("got volume %+v, want volume %v", volume, tt.wantVolume)
+			}
+			if volume == nil {
+				return
+			}
+
+			sources := volume.Projected.Sources
+			if len(sources) != 1 {
+				t.Fatalf("got sources %+v, want only the ca bundle", sources)
+			}
+			items := sources[0].Secret
+			if tt.tls.CABundle.ConfigMap != "" {
+				if sources[0].ConfigMap == nil {
+					t.Fatalf("got source %+v, want the config map", sources[0])
+				}
+				if path := sources[0].ConfigMap.Items[0].Path; path != "ca/ca.crt" {
+					t.Errorf("got path %s, want ca/ca.crt", path)
+				}
+			} else if items == nil || items.Items[0].Key != "root.pem" || items.Items[0].Path != "ca/ca.crt" {
+				t.Errorf("got source %+v, want the root.pem key of the secret at ca/ca.crt", sources[0])
+			}
+		})
+	}
+}
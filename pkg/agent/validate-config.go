@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	"github.com/Infisical/infisical-agent-injector/pkg/util/path"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ValidateConfigData validates the config.yaml of an agent config map without a pod to inject into, for each of the given operating systems.
+// the same config can be used by linux and windows pods, so it must be valid for every os it's used on
+func ValidateConfigData(data string, operatingSystems []string) error {
+	if data == "" {
+		return fmt.Errorf("%s is required", util.AgentConfigMapDataKey)
+	}
+
+	var errs []error
+	for _, os := range operatingSystems {
+		if err := validateConfigDataForOS(data, os); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", os, err))
+		}
+	}
+
+	// e.g. parse errors don't depend on the os, so they're only reported once
+	if len(errs) > 1 && len(errs) == len(operatingSystems) && slices.IndexFunc(errs, func(err error) bool {
+		return errors.Unwrap(err).Error() != errors.Unwrap(errs[0]).Error()
+	}) == -1 {
+		return errors.Unwrap(errs[0])
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateConfigDataForOS(data string, os string) error {
+	// parsed for every os, as creating the agent fills in defaults
+	// unknown fields are only warned about at injection, saving a config with a typo is rejected
+	configMap, err := util.ParseConfigMapDataStrict(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", util.AgentConfigMapDataKey, err)
+	}
+
+	isWindows := os == util.OSWindows
+
+	serviceAccountMountPath := path.Dir(util.LinuxKubernetesServiceAccountTokenPath, false)
+	if isWindows {
+		serviceAccountMountPath = path.Dir(util.WindowsKubernetesServiceAccountTokenPath, true)
+	}
+
+	// the sidecar mode supports every template option, pod specific options like the inject mode are validated at injection time
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				util.InjectModeAnnotation: util.InjectModeSidecar,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "app",
+				VolumeMounts: []corev1.VolumeMount{{
+					Name:      "kube-api-access",
+					MountPath: serviceAccountMountPath,
+				}},
+			}},
+		},
+	}
+
+	// a shared config may only set auth, leaving the templates to the inject template annotations of each pod
+	// the default destination path is a linux path, so the placeholder sets one that's valid on the os
+	if len(configMap.Templates) == 0 {
+		pod.Annotations[util.AnnotationInjectTemplatePrefix+"validation"] = "validation"
+		if isWindows {
+			pod.Annotations[util.AnnotationInjectDestinationPrefix+"validation"] = "C:\\infisical-secrets\\validation"
+		}
+	}
+
+	agent, err := NewAgent(pod, configMap, util.PodPlatform{OS: os}, slog.Default())
+	if err != nil {
+		return err
+	}
+
+	if err := agent.ValidateConfigMap(); err != nil {
+		return err
+	}
+
+	// checks the fields of the auth method
+	if _, _, err := util.BuildAgentConfigFromConfigMap(configMap, false, isWindows, util.InjectModeSidecar, false, pod.Annotations); err != nil {
+		return err
+	}
+
+	return nil
+}
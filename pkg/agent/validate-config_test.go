@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+)
+
+func TestValidateConfigData(t *testing.T) {
+	const auth = `
+infisical:
+  address: https://app.infisical.com
+  auth:
+    type: kubernetes
+    config:
+      identity-id: identity
+`
+	bothOS := []string{util.OSLinux, util.OSWindows}
+
+	tests := []struct {
+		name             string
+		data             string
+		operatingSystems []string
+		wantErr          string
+		notWantErr       string
+	}{
+		{
+			name:             "auth only",
+			data:             auth,
+			operatingSystems: bothOS,
+		},
+		{
+			name: "linux template",
+			data: auth + `
+templates:
+  - destination-path: /app/secrets/config.json
+    template-content: "{{ .Value }}"
+`,
+			operatingSystems: []string{util.OSLinux},
+		},
+		{
+			name: "windows template",
+			data: auth + `
+templates:
+  - destination-path: C:\app\secrets\config.json
+    template-content: "{{ .Value }}"
+`,
+			operatingSystems: []string{util.OSWindows},
+		},
+		{
+			name: "linux path validated for windows",
+			data: auth + `
+templates:
+  - destination-path: /app/secrets/config.json
+    template-content: "{{ .Value }}"
+`,
+			operatingSystems: bothOS,
+			wantErr:          util.OSWindows + ": ",
+			notWantErr:       util.OSLinux + ": ",
+		},
+		{
+			name:             "empty",
+			data:             "",
+			operatingSystems: bothOS,
+			wantErr:          util.AgentConfigMapDataKey + " is required",
+		},
+		{
+			name:             "unknown field is reported once",
+			data:             auth + "unknown: true\n",
+			operatingSystems: bothOS,
+			wantErr:          "failed to parse " + util.AgentConfigMapDataKey,
+			notWantErr:       util.OSLinux + ": ",
+		},
+		{
+			name: "missing identity id",
+			data: `
+infisical:
+  address: https://app.infisical.com
+  auth:
+    type: kubernetes
+    config: {}
+`,
+			operatingSystems: []string{util.OSLinux},
+			wantErr:          "identity-id is required for kubernetes auth",
+		},
+		{
+			name: "format template",
+			data: auth + `
+templates:
+  - destination-path: /app/secrets/.env
+    format: dotenv
+    secrets:
+      project-id: project
+      environment: dev
+`,
+			operatingSystems: []string{util.OSLinux},
+		},
+		{
+			name: "format template with template content",
+			data: auth + `
+templates:
+  - destination-path: /app/secrets/.env
+    format: dotenv
+    template-content: "{{ .Value }}"
+    secrets:
+      project-id: project
+      environment: dev
+`,
+			operatingSystems: []string{util.OSLinux},
+			wantErr:          "can't be combined with source-path, template-content or base64-template-content",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfigData(tt.data, tt.operatingSystems)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if tt.notWantErr != "" && strings.Contains(err.Error(), tt.notWantErr) {
+				t.Errorf("got error %v, which shouldn't contain %q", err, tt.notWantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+)
+
+// warn records a non-fatal issue, returned to the client as an admission warning. duplicates are dropped as the
+// container helpers are called more than once per pod
+func (a *Agent) warn(format string, args ...any) {
+	warning := fmt.Sprintf(format, args...)
+	if !slices.Contains(a.warnings, warning) {
+		a.warnings = append(a.warnings, warning)
+	}
+}
+
+// Warnings returns the issues found while validating the config and patching the pod
+func (a *Agent) Warnings() []string {
+	return a.warnings
+}
+
+func (a *Agent) isSecretVolume(name string) bool {
+	templateCount := len(a.configMap.Templates)
+	for i := range a.configMap.Templates {
+		if secretVolumeName(i, templateCount) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Agent) collectConfigWarnings() {
+	for _, warning := range a.configMap.Warnings {
+		a.warn("%s", warning)
+	}
+
+	if a.configMap.Infisical.Auth.Type == util.LdapAuthType {
+		if _, ok := a.configMap.Infisical.Auth.Config["password"].(string); ok {
+			// the config may come from an agent config resource, so the config map annotation can be empty
+			a.warn("the ldap password of %s is set in plain text on the agent containers, anyone who can read the pod can read it", a.configMap.Source)
+		}
+	}
+
+	revokeOnShutdown := a.configMap.Infisical.RevokeCredentialsOnShutdown || a.pod.Annotations[util.AnnotationRevokeCredentialsOnShutdown] == "true"
+	if revokeOnShutdown && a.injectMode == util.InjectModeInit {
+		a.warn("revoke-credentials-on-shutdown is ignored in inject mode %s, the agent exits after rendering the secrets", util.InjectModeInit)
+	}
+
+	// the default images are managed by the injector, so only images set on the pod are checked
+	if image := a.pod.Annotations[util.AnnotationAgentImage]; image != "" && !strings.Contains(image, "@sha256:") {
+		a.warn("agent image %s is referenced by tag, use a digest to make sure the same image runs on every node", image)
+	}
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+)
+
+func TestCollectConfigWarnings(t *testing.T) {
+	ldapPasswordWarning := "the ldap password of clusterinfisicalagentconfig/ldap is set in plain text on the agent containers, anyone who can read the pod can read it"
+
+	tests := []struct {
+		name        string
+		authType    string
+		authConfig  map[string]interface{}
+		annotations map[string]string
+		configWarns []string
+		want        []string
+	}{
+		{
+			name:       "no warnings",
+			authType:   util.KubernetesAuthType,
+			authConfig: map[string]interface{}{"identity-id": "identity"},
+		},
+		{
+			name:       "ldap password names the config source",
+			authType:   util.LdapAuthType,
+			authConfig: map[string]interface{}{"username": "agent", "password": "hunter2"},
+			want:       []string{ldapPasswordWarning},
+		},
+		{
+			name:       "ldap password from a secret",
+			authType:   util.LdapAuthType,
+			authConfig: map[string]interface{}{"username": "agent", "password": map[string]interface{}{"secret": "ldap"}},
+		},
+		{
+			name:        "config warnings come first",
+			authType:    util.LdapAuthType,
+			authConfig:  map[string]interface{}{"password": "hunter2"},
+			configWarns: []string{"unknown field typo"},
+			want:        []string{"unknown field typo", ldapPasswordWarning},
+		},
+		{
+			name:        "agent image by tag",
+			authType:    util.KubernetesAuthType,
+			annotations: map[string]string{util.AnnotationAgentImage: "registry.example.com/infisical/cli:0.43.55"},
+			want:        []string{"agent image registry.example.com/infisical/cli:0.43.55 is referenced by tag, use a digest to make sure the same image runs on every node"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newTestAgent(t, tt.annotations, util.OSLinux, "/shared/secrets")
+			agent.warnings = nil
+			agent.configMap.Source = "clusterinfisicalagentconfig/ldap"
+			agent.configMap.Warnings = tt.configWarns
+			agent.configMap.Infisical.Auth.Type = tt.authType
+			agent.configMap.Infisical.Auth.Config = tt.authConfig
+
+			agent.collectConfigWarnings()
+
+			if got := agent.Warnings(); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const agentConfigStatusInterval = 30 * time.Second
+
+// AgentConfigStatusController periodically reports on the status of every InfisicalAgentConfig and ClusterInfisicalAgentConfig how many injected pods use it.
+// injected pods are counted from an informer that only caches pods with the injected label, instead of listing every pod in the cluster
+type AgentConfigStatusController struct {
+	dynamicClient   dynamic.Interface
+	informerFactory informers.SharedInformerFactory
+	podLister       listerscorev1.PodLister
+	podSynced       cache.InformerSynced
+}
+
+func NewAgentConfigStatusController(client kubernetes.Interface, dynamicClient dynamic.Interface) *AgentConfigStatusController {
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = util.LabelAgentInjected + "=true"
+	}))
+	podInformer := informerFactory.Core().V1().Pods()
+
+	// only the metadata is used, so the rest of the pod isn't kept in memory
+	_ = podInformer.Informer().SetTransform(func(obj interface{}) (interface{}, error) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return obj, nil
+		}
+		metadata := pod.ObjectMeta.DeepCopy()
+		metadata.ManagedFields = nil
+		return &corev1.Pod{ObjectMeta: *metadata}, nil
+	})
+
+	return &AgentConfigStatusController{
+		dynamicClient:   dynamicClient,
+		informerFactory: informerFactory,
+		podLister:       podInformer.Lister(),
+		podSynced:       podInformer.Informer().HasSynced,
+	}
+}
+
+func (c *AgentConfigStatusController) Run(ctx context.Context) {
+	slog.Info("Starting agent config status controller...")
+
+	c.informerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.podSynced) {
+		slog.Warn("Failed to sync pod cache, agent config status will not be reported")
+		return
+	}
+
+	ticker := time.NewTicker(agentConfigStatusInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.sync(ctx); err != nil {
+			slog.Error("Error syncing agent config status", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *AgentConfigStatusController) sync(ctx context.Context) error {
+	agentConfigs, err := c.listAgentConfigs(ctx, util.AgentConfigGVR)
+	if err != nil {
+		return err
+	}
+	clusterAgentConfigs, err := c.listAgentConfigs(ctx, util.ClusterAgentConfigGVR)
+	if err != nil {
+		return err
+	}
+
+	if len(agentConfigs) == 0 && len(clusterAgentConfigs) == 0 {
+		return nil
+	}
+
+	pods, err := c.podLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	podCounts := map[types.NamespacedName]int64{}
+	clusterPodCounts := map[string]int64{}
+	for _, pod := range pods {
+		if pod.Annotations[util.AnnotationAgentStatus] != "injected" || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		kind, name, _ := strings.Cut(pod.Annotations[util.AnnotationAgentConfigSource], "/")
+		// pods injected before the source annotation was added only reference the config by name
+		if kind == "" && pod.Annotations[util.AnnotationAgentConfigResource] != "" {
+			kind, name = strings.ToLower(util.AgentConfigKind), pod.Annotations[util.AnnotationAgentConfigResource]
+		}
+
+		switch kind {
+		case strings.ToLower(util.AgentConfigKind):
+			podCounts[types.NamespacedName{Namespace: pod.Namespace, Name: name}]++
+		case strings.ToLower(util.ClusterAgentConfigKind):
+			clusterPodCounts[name]++
+		}
+	}
+
+	for _, agentConfig := range agentConfigs {
+		if err := c.updateStatus(ctx, util.AgentConfigGVR, agentConfig, podCounts[types.NamespacedName{Namespace: agentConfig.GetNamespace(), Name: agentConfig.GetName()}]); err != nil {
+			slog.Error("Failed to update agent config status", "namespace", agentConfig.GetNamespace(), "name", agentConfig.GetName(), "error", err)
+		}
+	}
+
+	for _, clusterAgentConfig := range clusterAgentConfigs {
+		if err := c.updateStatus(ctx, util.ClusterAgentConfigGVR, clusterAgentConfig, clusterPodCounts[clusterAgentConfig.GetName()]); err != nil {
+			slog.Error("Failed to update cluster agent config status", "name", clusterAgentConfig.GetName(), "error", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *AgentConfigStatusController) listAgentConfigs(ctx context.Context, gvr schema.GroupVersionResource) ([]unstructured.Unstructured, error) {
+	agentConfigs, err := c.dynamicClient.Resource(gvr).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		// the custom resource definitions are optional
+		if apierrors.IsNotFound(err) {
+			slog.Debug("Agent config resource is not installed, skipping status sync", "resource", gvr.Resource)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return agentConfigs.Items, nil
+}
+
+func (c *AgentConfigStatusController) updateStatus(ctx context.Context, gvr schema.GroupVersionResource, agentConfig unstructured.Unstructured, podCount int64) error {
+	currentCount, _, _ := unstructured.NestedInt64(agentConfig.Object, "status", "pods")
+	observedGeneration, _, _ := unstructured.NestedInt64(agentConfig.Object, "status", "observedGeneration")
+
+	if currentCount == podCount && observedGeneration == agentConfig.GetGeneration() {
+		return nil
+	}
+
+	if err := unstructured.SetNestedField(agentConfig.Object, podCount, "status", "pods"); err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedField(agentConfig.Object, agentConfig.GetGeneration(), "status", "observedGeneration"); err != nil {
+		return err
+	}
+
+	_, err := c.dynamicClient.Resource(gvr).Namespace(agentConfig.GetNamespace()).UpdateStatus(ctx, &agentConfig, metav1.UpdateOptions{})
+	// every injector replica runs this controller, a conflict means another replica already updated the status
+	if apierrors.IsConflict(err) {
+		slog.Debug("Agent config status was updated concurrently, skipping", "namespace", agentConfig.GetNamespace(), "name", agentConfig.GetName())
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAgentConfigStatusControllerSync(t *testing.T) {
+	newAgentConfig := func(kind string, namespace string, name string) *unstructured.Unstructured {
+		agentConfig := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		agentConfig.SetAPIVersion(util.AgentConfigGroup + "/" + util.AgentConfigVersion)
+		agentConfig.SetKind(kind)
+		agentConfig.SetNamespace(namespace)
+		agentConfig.SetName(name)
+		agentConfig.SetGeneration(2)
+		return agentConfig
+	}
+	injectedPod := func(name string, namespace string, annotations map[string]string) *corev1.Pod {
+		if annotations[util.AnnotationAgentStatus] == "" {
+			annotations[util.AnnotationAgentStatus] = "injected"
+		}
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{util.LabelAgentInjected: "true"},
+			Annotations: annotations,
+		}}
+	}
+	deletingPod := injectedPod("api-deleting", "apps", map[string]string{util.AnnotationAgentConfigSource: "infisicalagentconfig/api"})
+	deletingPod.DeletionTimestamp = &metav1.Time{}
+
+	pods := []*corev1.Pod{
+		injectedPod("api-1", "apps", map[string]string{util.AnnotationAgentConfigSource: "infisicalagentconfig/api"}),
+		// injected before the source annotation was added
+		injectedPod("api-2", "apps", map[string]string{util.AnnotationAgentConfigResource: "api"}),
+		// same name in another namespace
+		injectedPod("api-3", "other", map[string]string{util.AnnotationAgentConfigSource: "infisicalagentconfig/api"}),
+		injectedPod("web-1", "apps", map[string]string{util.AnnotationAgentConfigSource: "clusterinfisicalagentconfig/shared"}),
+		injectedPod("web-2", "other", map[string]string{util.AnnotationAgentConfigSource: "clusterinfisicalagentconfig/shared"}),
+		injectedPod("failed", "apps", map[string]string{util.AnnotationAgentStatus: "failed", util.AnnotationAgentConfigSource: "infisicalagentconfig/api"}),
+		injectedPod("config-map", "apps", map[string]string{util.AnnotationAgentConfigSource: "configmap/agent-config"}),
+		deletingPod,
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		util.AgentConfigGVR:        util.AgentConfigKind + "List",
+		util.ClusterAgentConfigGVR: util.ClusterAgentConfigKind + "List",
+	},
+		newAgentConfig(util.AgentConfigKind, "apps", "api"),
+		newAgentConfig(util.AgentConfigKind, "apps", "unused"),
+		newAgentConfig(util.ClusterAgentConfigKind, "", "shared"),
+	)
+
+	c := NewAgentConfigStatusController(fake.NewSimpleClientset(), dynamicClient)
+	podIndexer := c.informerFactory.Core().V1().Pods().Informer().GetIndexer()
+	for _, pod := range pods {
+		if err := podIndexer.Add(pod); err != nil {
+			t.Fatalf("failed to add pod %s: %v", pod.Name, err)
+		}
+	}
+
+	ctx := context.TODO()
+	if err := c.sync(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		gvr       schema.GroupVersionResource
+		namespace string
+		name      string
+		wantPods  int64
+	}{
+		{gvr: util.AgentConfigGVR, namespace: "apps", name: "api", wantPods: 2},
+		{gvr: util.AgentConfigGVR, namespace: "apps", name: "unused", wantPods: 0},
+		{gvr: util.ClusterAgentConfigGVR, name: "shared", wantPods: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agentConfig, err := dynamicClient.Resource(tt.gvr).Namespace(tt.namespace).Get(ctx, tt.name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get %s: %v", tt.name, err)
+			}
+
+			pods, found, _ := unstructured.NestedInt64(agentConfig.Object, "status", "pods")
+			if !found || pods != tt.wantPods {
+				t.Errorf("got %d pods (found %v), want %d", pods, found, tt.wantPods)
+			}
+			if observedGeneration, _, _ := unstructured.NestedInt64(agentConfig.Object, "status", "observedGeneration"); observedGeneration != 2 {
+				t.Errorf("got observed generation %d, want 2", observedGeneration)
+			}
+		})
+	}
+}
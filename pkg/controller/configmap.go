@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// ConfigMapController watches agent config maps labelled with org.infisical.com/agent-config and detects pods that were injected with an outdated config.
+// workloads that opted in with the restart-on-config-change annotation get a rollout restart, so new pods pick up the new config.
+type ConfigMapController struct {
+	client          kubernetes.Interface
+	informerFactory informers.SharedInformerFactory
+	configMapLister listerscorev1.ConfigMapLister
+	configMapSynced cache.InformerSynced
+	queue           workqueue.TypedRateLimitingInterface[string]
+}
+
+type workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func NewConfigMapController(client kubernetes.Interface) (*ConfigMapController, error) {
+	// only config maps labelled as agent configs are cached, instead of every config map in the cluster
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = util.LabelAgentConfig + "=true"
+	}))
+	configMapInformer := informerFactory.Core().V1().ConfigMaps()
+
+	c := &ConfigMapController{
+		client:          client,
+		informerFactory: informerFactory,
+		configMapLister: configMapInformer.Lister(),
+		configMapSynced: configMapInformer.Informer().HasSynced,
+		queue:           workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+	}
+
+	_, err := configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldConfigMap, ok := oldObj.(*corev1.ConfigMap)
+			if !ok {
+				return
+			}
+			newConfigMap, ok := newObj.(*corev1.ConfigMap)
+			if !ok {
+				return
+			}
+
+			// resyncs and changes to other keys don't affect the agent config
+			if oldConfigMap.Data[util.AgentConfigMapDataKey] == newConfigMap.Data[util.AgentConfigMapDataKey] {
+				return
+			}
+
+			key, err := cache.MetaNamespaceKeyFunc(newConfigMap)
+			if err != nil {
+				slog.Error("Failed to get key for config map", "namespace", newConfigMap.Namespace, "config_map", newConfigMap.Name, "error", err)
+				return
+			}
+			c.queue.Add(key)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add config map event handler: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *ConfigMapController) Run(ctx context.Context) {
+	defer c.queue.ShutDown()
+
+	slog.Info("Starting config map controller...")
+
+	c.informerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.configMapSynced) {
+		slog.Warn("Failed to sync config map cache, config changes will not be detected")
+		return
+	}
+
+	go func() {
+		for c.processNextItem(ctx) {
+		}
+	}()
+
+	<-ctx.Done()
+}
+
+func (c *ConfigMapController) processNextItem(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.sync(ctx, key); err != nil {
+		slog.Error("Error syncing config map, retrying...", "key", key, "error", err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *ConfigMapController) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	configMap, err := c.configMapLister.ConfigMaps(namespace).Get(name)
+	if err != nil {
+		return fmt.Errorf("failed to get config map: %w", err)
+	}
+
+	configHash := util.HashConfigData(configMap.Data[util.AgentConfigMapDataKey])
+
+	pods, err := c.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: util.LabelAgentInjected + "=true",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+	}
+
+	workloads := map[workload]bool{}
+	for _, pod := range pods.Items {
+		if pod.Annotations[util.AnnotationAgentConfigMap] != name || pod.Annotations[util.AnnotationAgentStatus] != "injected" {
+			continue
+		}
+
+		if pod.Annotations[util.AnnotationAgentConfigHash] == configHash || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		if pod.Annotations[util.AnnotationRestartOnConfigChange] != "true" {
+			slog.Info("Pod is running an outdated agent config", "namespace", namespace, "pod", pod.Name, "config_map", name)
+			continue
+		}
+
+		owner, err := c.getOwnerWorkload(ctx, &pod)
+		if err != nil {
+			return err
+		}
+		if owner == nil {
+			slog.Info("Pod is running an outdated agent config but is not owned by a deployment, statefulset or daemonset, skipping restart", "namespace", namespace, "pod", pod.Name, "config_map", name)
+			continue
+		}
+
+		workloads[*owner] = true
+	}
+
+	for owner := range workloads {
+		if err := c.restartWorkload(ctx, owner); err != nil {
+			return err
+		}
+		slog.Info("Restarted workload after config map changed", "namespace", owner.Namespace, "kind", owner.Kind, "name", owner.Name, "config_map", name)
+	}
+
+	return nil
+}
+
+func (c *ConfigMapController) getOwnerWorkload(ctx context.Context, pod *corev1.Pod) (*workload, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return nil, nil
+	}
+
+	switch owner.Kind {
+	case "StatefulSet", "DaemonSet":
+		return &workload{Kind: owner.Kind, Namespace: pod.Namespace, Name: owner.Name}, nil
+	case "ReplicaSet":
+		replicaSet, err := c.client.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get replica set %s in namespace %s: %w", owner.Name, pod.Namespace, err)
+		}
+
+		replicaSetOwner := metav1.GetControllerOf(replicaSet)
+		if replicaSetOwner == nil || replicaSetOwner.Kind != "Deployment" {
+			return nil, nil
+		}
+		return &workload{Kind: replicaSetOwner.Kind, Namespace: pod.Namespace, Name: replicaSetOwner.Name}, nil
+	}
+
+	return nil, nil
+}
+
+// restartWorkload does the same as `kubectl rollout restart` by updating an annotation on the pod template
+func (c *ConfigMapController) restartWorkload(ctx context.Context, owner workload) error {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						util.AnnotationRestartedAt: time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	}
+
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal restart patch: %w", err)
+	}
+
+	switch owner.Kind {
+	case "Deployment":
+		_, err = c.client.AppsV1().Deployments(owner.Namespace).Patch(ctx, owner.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case "StatefulSet":
+		_, err = c.client.AppsV1().StatefulSets(owner.Namespace).Patch(ctx, owner.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	case "DaemonSet":
+		_, err = c.client.AppsV1().DaemonSets(owner.Namespace).Patch(ctx, owner.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
+	default:
+		return fmt.Errorf("unsupported workload kind: %s", owner.Kind)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to restart %s %s in namespace %s: %w", owner.Kind, owner.Name, owner.Namespace, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestConfigMapControllerSync(t *testing.T) {
+	const newConfig = "templates: []\n"
+	outdatedHash := util.HashConfigData("templates: [old]\n")
+	currentHash := util.HashConfigData(newConfig)
+
+	controllerOf := func(kind string, name string) []metav1.OwnerReference {
+		isController := true
+		return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &isController}}
+	}
+	injectedPod := func(name string, configHash string, restart bool, owners []metav1.OwnerReference) *corev1.Pod {
+		annotations := map[string]string{
+			util.AnnotationAgentConfigMap:  "agent-config",
+			util.AnnotationAgentStatus:     "injected",
+			util.AnnotationAgentConfigHash: configHash,
+		}
+		if restart {
+			annotations[util.AnnotationRestartOnConfigChange] = "true"
+		}
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "apps",
+			Labels:          map[string]string{util.LabelAgentInjected: "true"},
+			Annotations:     annotations,
+			OwnerReferences: owners,
+		}}
+	}
+
+	tests := []struct {
+		name          string
+		objects       []runtime.Object
+		wantRestarted []string
+	}{
+		{
+			name: "deployment through its replica set",
+			objects: []runtime.Object{
+				injectedPod("api-1", outdatedHash, true, controllerOf("ReplicaSet", "api-abc")),
+				injectedPod("api-2", outdatedHash, true, controllerOf("ReplicaSet", "api-abc")),
+				&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "api-abc", Namespace: "apps", OwnerReferences: controllerOf("Deployment", "api")}},
+				&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "apps"}},
+			},
+			wantRestarted: []string{"deployments/api"},
+		},
+		{
+			name: "stateful set and daemon set",
+			objects: []runtime.Object{
+				injectedPod("db-0", outdatedHash, true, controllerOf("StatefulSet", "db")),
+				injectedPod("agent-x", outdatedHash, true, controllerOf("DaemonSet", "agent")),
+				&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "apps"}},
+				&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "apps"}},
+			},
+			wantRestarted: []string{"daemonsets/agent", "statefulsets/db"},
+		},
+		{
+			name: "current config",
+			objects: []runtime.Object{
+				injectedPod("db-0", currentHash, true, controllerOf("StatefulSet", "db")),
+			},
+		},
+		{
+			name: "not opted in",
+			objects: []runtime.Object{
+				injectedPod("db-0", outdatedHash, false, controllerOf("StatefulSet", "db")),
+			},
+		},
+		{
+			name: "bare pod and replica set without deployment",
+			objects: []runtime.Object{
+				injectedPod("bare", outdatedHash, true, nil),
+				injectedPod("rs-1", outdatedHash, true, controllerOf("ReplicaSet", "standalone")),
+				&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "standalone", Namespace: "apps"}},
+			},
+		},
+		{
+			name: "pods that aren't labelled as injected",
+			objects: []runtime.Object{
+				func() *corev1.Pod {
+					pod := injectedPod("db-0", outdatedHash, true, controllerOf("StatefulSet", "db"))
+					pod.Labels = nil
+					return pod
+				}(),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "agent-config", Namespace: "apps", Labels: map[string]string{util.LabelAgentConfig: "true"}},
+				Data:       map[string]string{util.AgentConfigMapDataKey: newConfig},
+			}
+			client := fake.NewSimpleClientset(append(tt.objects, configMap)...)
+
+			c, err := NewConfigMapController(client)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := c.informerFactory.Core().V1().ConfigMaps().Informer().GetIndexer().Add(configMap); err != nil {
+				t.Fatalf("failed to add config map to the cache: %v", err)
+			}
+
+			if err := c.sync(context.Background(), "apps/agent-config"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var restarted []string
+			for _, action := range client.Actions() {
+				if patch, ok := action.(k8stesting.PatchAction); ok {
+					restarted = append(restarted, patch.GetResource().Resource+"/"+patch.GetName())
+				}
+			}
+			slices.Sort(restarted)
+
+			if !slices.Equal(restarted, tt.wantRestarted) {
+				t.Errorf("got restarted workloads %v, want %v", restarted, tt.wantRestarted)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package envexec
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+)
+
+type envFiles []string
+
+func (e *envFiles) String() string {
+	return strings.Join(*e, ",")
+}
+
+func (e *envFiles) Set(value string) error {
+	*e = append(*e, value)
+	return nil
+}
+
+// Run is the entrypoint of the env-exec command, which wraps the entrypoint of app containers.
+// it loads the rendered dotenv templates into the environment and replaces itself with the original entrypoint.
+func Run(args []string) error {
+	var files envFiles
+
+	flags := flag.NewFlagSet("env-exec", flag.ExitOnError)
+	flags.Var(&files, "env-file", "rendered dotenv template to load, can be repeated")
+	timeout := flags.Duration("timeout", util.DefaultEnvExecTimeoutSecs*time.Second, "how long to wait for the agent to render the templates, set to the init timeout of the pod")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	command := flags.Args()
+	if len(command) == 0 {
+		return fmt.Errorf("no command to execute")
+	}
+
+	dotenvs := map[string][]byte{}
+	for _, file := range files {
+		content, err := waitForFile(file, *timeout)
+		if err != nil {
+			return err
+		}
+
+		values, err := util.ParseDotenv(content)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		maps.Copy(dotenvs, values)
+	}
+
+	env := mergeEnv(os.Environ(), dotenvs)
+
+	// resolve the command with the PATH of the rendered env, in case it was overridden
+	for _, variable := range env {
+		if value, found := strings.CutPrefix(variable, "PATH="); found {
+			os.Setenv("PATH", value)
+		}
+	}
+
+	binary, err := exec.LookPath(command[0])
+	if err != nil {
+		return fmt.Errorf("failed to find command %s: %w", command[0], err)
+	}
+
+	return execCommand(binary, command, env)
+}
+
+// mergeEnv overrides the container env with the rendered dotenv values, so every variable is only set once
+func mergeEnv(environ []string, dotenvs map[string][]byte) []string {
+	variables := map[string]string{}
+	for _, variable := range environ {
+		key, value, _ := strings.Cut(variable, "=")
+		variables[key] = value
+	}
+
+	for key, value := range dotenvs {
+		variables[key] = string(value)
+	}
+
+	env := make([]string, 0, len(variables))
+	for _, key := range slices.Sorted(maps.Keys(variables)) {
+		env = append(env, fmt.Sprintf("%s=%s", key, variables[key]))
+	}
+
+	return env
+}
+
+// waitForFile waits for the sidecar agent to render the template, the init agent will already have rendered it
+func waitForFile(file string, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		content, err := os.ReadFile(file)
+		if err == nil {
+			return content, nil
+		}
+
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read env file %s: %w", file, err)
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for env file %s", timeout, file)
+		}
+
+		slog.Info("Waiting for env file to be rendered...", "path", file)
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,68 @@
+package envexec
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		dotenvs map[string][]byte
+		want    []string
+	}{
+		{
+			name:    "dotenv values are added",
+			environ: []string{"HOME=/root"},
+			dotenvs: map[string][]byte{"DB_PASSWORD": []byte("secret")},
+			want:    []string{"DB_PASSWORD=secret", "HOME=/root"},
+		},
+		{
+			name:    "dotenv values override the container env",
+			environ: []string{"PATH=/usr/bin", "DB_HOST=localhost"},
+			dotenvs: map[string][]byte{"PATH": []byte("/opt/app/bin:/usr/bin"), "DB_HOST": []byte("db")},
+			want:    []string{"DB_HOST=db", "PATH=/opt/app/bin:/usr/bin"},
+		},
+		{
+			name:    "values with equal signs and new lines",
+			environ: []string{"OPTS=a=b"},
+			dotenvs: map[string][]byte{"KEY": []byte("line1\nline2=x")},
+			want:    []string{"KEY=line1\nline2=x", "OPTS=a=b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeEnv(tt.environ, tt.dotenvs); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForFile(t *testing.T) {
+	dir := t.TempDir()
+
+	rendered := filepath.Join(dir, "rendered.env")
+	if err := os.WriteFile(rendered, []byte("A=1\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	content, err := waitForFile(rendered, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != "A=1\n" {
+		t.Errorf("got %q, want %q", content, "A=1\n")
+	}
+
+	_, err = waitForFile(filepath.Join(dir, "missing.env"), 0)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 0s waiting for env file") {
+		t.Errorf("got error %v, want a timeout", err)
+	}
+}
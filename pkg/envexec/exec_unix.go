@@ -0,0 +1,10 @@
+//go:build !windows
+
+package envexec
+
+import "syscall"
+
+// execCommand replaces the current process, so the app runs as PID 1 and receives signals directly
+func execCommand(binary string, command []string, env []string) error {
+	return syscall.Exec(binary, command, env)
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package envexec
+
+import "fmt"
+
+func execCommand(binary string, command []string, env []string) error {
+	return fmt.Errorf("env-exec is not supported on windows")
+}
@@ -0,0 +1,178 @@
+package injector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// AgentConfigCache serves the agent config resources and namespaces used to resolve the config of a pod from informers, so resolving a config
+// doesn't add api calls to the admission path. the custom resources are only cached if their definitions are installed when the injector starts,
+// otherwise (or if the cache is nil) the lookups fall back to the api
+type AgentConfigCache struct {
+	client                   kubernetes.Interface
+	dynamicClient            dynamic.Interface
+	namespaceLister          listerscorev1.NamespaceLister
+	agentConfigLister        cache.GenericLister
+	clusterAgentConfigLister cache.GenericLister
+}
+
+// NewAgentConfigCache starts the informers and waits for them to sync
+func NewAgentConfigCache(ctx context.Context, client kubernetes.Interface, dynamicClient dynamic.Interface) (*AgentConfigCache, error) {
+	c := &AgentConfigCache{
+		client:        client,
+		dynamicClient: dynamicClient,
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(client, 0)
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	// only the namespace labels are needed to match the namespace selectors
+	if err := namespaceInformer.Informer().SetTransform(func(object interface{}) (interface{}, error) {
+		if namespace, ok := object.(*corev1.Namespace); ok {
+			return &corev1.Namespace{ObjectMeta: namespace.ObjectMeta}, nil
+		}
+		return object, nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to set namespace informer transform: %w", err)
+	}
+	c.namespaceLister = namespaceInformer.Lister()
+	synced := []cache.InformerSynced{namespaceInformer.Informer().HasSynced}
+
+	servedResources, err := servedAgentConfigResources(client)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+	if slices.Contains(servedResources, util.AgentConfigResource) {
+		agentConfigInformer := dynamicInformerFactory.ForResource(util.AgentConfigGVR)
+		c.agentConfigLister = agentConfigInformer.Lister()
+		synced = append(synced, agentConfigInformer.Informer().HasSynced)
+	}
+	if slices.Contains(servedResources, util.ClusterAgentConfigResource) {
+		clusterAgentConfigInformer := dynamicInformerFactory.ForResource(util.ClusterAgentConfigGVR)
+		c.clusterAgentConfigLister = clusterAgentConfigInformer.Lister()
+		synced = append(synced, clusterAgentConfigInformer.Informer().HasSynced)
+	}
+	slog.Info("Caching agent config resources", "resources", servedResources)
+
+	informerFactory.Start(ctx.Done())
+	dynamicInformerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return nil, fmt.Errorf("failed to sync agent config cache")
+	}
+
+	return c, nil
+}
+
+// servedAgentConfigResources returns which of the agent config custom resources are installed
+func servedAgentConfigResources(client kubernetes.Interface) ([]string, error) {
+	resourceList, err := client.Discovery().ServerResourcesForGroupVersion(schema.GroupVersion{Group: util.AgentConfigGroup, Version: util.AgentConfigVersion}.String())
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to discover %s resources: %w", util.AgentConfigGroup, err)
+	}
+
+	var resources []string
+	for _, resource := range resourceList.APIResources {
+		resources = append(resources, resource.Name)
+	}
+
+	return resources, nil
+}
+
+func (c *AgentConfigCache) getAgentConfig(namespace string, name string) (*unstructured.Unstructured, error) {
+	if c.agentConfigLister != nil {
+		object, err := c.agentConfigLister.ByNamespace(namespace).Get(name)
+		if err == nil {
+			if agentConfig, ok := object.(*unstructured.Unstructured); ok {
+				return agentConfig.DeepCopy(), nil
+			}
+		}
+		// a config created right before the pod may not be cached yet
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return c.dynamicClient.Resource(util.AgentConfigGVR).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (c *AgentConfigCache) listAgentConfigs(namespace string) ([]unstructured.Unstructured, error) {
+	if c.agentConfigLister != nil {
+		objects, err := c.agentConfigLister.ByNamespace(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		return toUnstructuredList(objects), nil
+	}
+
+	return c.listFromAPI(util.AgentConfigGVR, namespace)
+}
+
+func (c *AgentConfigCache) listClusterAgentConfigs() ([]unstructured.Unstructured, error) {
+	if c.clusterAgentConfigLister != nil {
+		objects, err := c.clusterAgentConfigLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		return toUnstructuredList(objects), nil
+	}
+
+	return c.listFromAPI(util.ClusterAgentConfigGVR, metav1.NamespaceAll)
+}
+
+func (c *AgentConfigCache) listFromAPI(gvr schema.GroupVersionResource, namespace string) ([]unstructured.Unstructured, error) {
+	list, err := c.dynamicClient.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		// the custom resource definitions are optional
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+func (c *AgentConfigCache) getNamespace(name string) (*corev1.Namespace, error) {
+	if c.namespaceLister != nil {
+		namespace, err := c.namespaceLister.Get(name)
+		if err == nil {
+			return namespace, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return c.client.CoreV1().Namespaces().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func toUnstructuredList(objects []runtime.Object) []unstructured.Unstructured {
+	var items []unstructured.Unstructured
+	for _, object := range objects {
+		if item, ok := object.(*unstructured.Unstructured); ok {
+			items = append(items, *item.DeepCopy())
+		}
+	}
+	return items
+}
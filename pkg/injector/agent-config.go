@@ -0,0 +1,188 @@
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// GetAgentConfigResource reads an InfisicalAgentConfig and converts its spec to the config map format
+func GetAgentConfigResource(agentConfigCache *AgentConfigCache, namespace string, name string) (*util.ConfigMap, error) {
+	agentConfig, err := agentConfigCache.getAgentConfig(namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s in namespace %s: %w", util.AgentConfigKind, name, namespace, err)
+	}
+
+	configMap, err := AgentConfigSpecToConfigMap(agentConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %s in namespace %s: %w", util.AgentConfigKind, name, namespace, err)
+	}
+
+	return configMap, nil
+}
+
+// FindNamespaceAgentConfig returns the InfisicalAgentConfig in the namespace of the pod whose pod-selector matches the pod, or nil if none matches.
+// configs without a pod-selector only apply to pods that reference them by name
+func FindNamespaceAgentConfig(agentConfigCache *AgentConfigCache, pod corev1.Pod) (*util.ConfigMap, error) {
+	agentConfigs, err := agentConfigCache.listAgentConfigs(pod.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s in namespace %s: %w", util.AgentConfigResource, pod.Namespace, err)
+	}
+
+	matches, err := matchingAgentConfigs(agentConfigs, pod, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return selectAgentConfig(matches, util.AgentConfigKind)
+}
+
+// FindClusterAgentConfig returns the ClusterInfisicalAgentConfig whose namespace-selector and pod-selector match the pod, or nil if none matches
+func FindClusterAgentConfig(agentConfigCache *AgentConfigCache, pod corev1.Pod) (*util.ConfigMap, error) {
+	clusterAgentConfigs, err := agentConfigCache.listClusterAgentConfigs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", util.ClusterAgentConfigResource, err)
+	}
+
+	if len(clusterAgentConfigs) == 0 {
+		return nil, nil
+	}
+
+	namespace, err := agentConfigCache.getNamespace(pod.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %w", pod.Namespace, err)
+	}
+
+	matches, err := matchingAgentConfigs(clusterAgentConfigs, pod, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return selectAgentConfig(matches, util.ClusterAgentConfigKind)
+}
+
+// matchingAgentConfigs filters the configs by their selectors. the namespace selector is only checked if namespace is set (cluster configs)
+func matchingAgentConfigs(agentConfigs []unstructured.Unstructured, pod corev1.Pod, namespace *corev1.Namespace) ([]unstructured.Unstructured, error) {
+	var matches []unstructured.Unstructured
+
+	for _, agentConfig := range agentConfigs {
+		podSelectorMatches, err := selectorMatches(agentConfig, "pod-selector", pod.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if !podSelectorMatches {
+			continue
+		}
+
+		if namespace != nil {
+			namespaceSelectorMatches, err := selectorMatches(agentConfig, "namespace-selector", namespace.Labels)
+			if err != nil {
+				return nil, err
+			}
+			if !namespaceSelectorMatches {
+				continue
+			}
+		}
+
+		matches = append(matches, agentConfig)
+	}
+
+	return matches, nil
+}
+
+// selectorMatches returns false if the selector isn't set, an empty selector matches everything
+func selectorMatches(agentConfig unstructured.Unstructured, field string, objectLabels map[string]string) (bool, error) {
+	rawSelector, found, err := unstructured.NestedMap(agentConfig.Object, "spec", field)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s of %s: %w", field, agentConfig.GetName(), err)
+	}
+	if !found {
+		return false, nil
+	}
+
+	var labelSelector metav1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawSelector, &labelSelector); err != nil {
+		return false, fmt.Errorf("invalid %s of %s: %w", field, agentConfig.GetName(), err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s of %s: %w", field, agentConfig.GetName(), err)
+	}
+
+	return selector.Matches(labels.Set(objectLabels)), nil
+}
+
+// selectAgentConfig converts the only match. multiple matches are an error, as picking one would depend on the order the configs were created in
+func selectAgentConfig(matches []unstructured.Unstructured, kind string) (*util.ConfigMap, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	if len(matches) > 1 {
+		var names []string
+		for _, match := range matches {
+			names = append(names, match.GetName())
+		}
+		return nil, fmt.Errorf("multiple %s resources match the pod (%s), set %s to pick one", kind, strings.Join(names, ", "), util.AnnotationAgentConfigResource)
+	}
+
+	configMap, err := AgentConfigSpecToConfigMap(&matches[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %s: %w", kind, matches[0].GetName(), err)
+	}
+
+	return configMap, nil
+}
+
+// AgentConfigSpecToConfigMap converts the spec of an InfisicalAgentConfig or ClusterInfisicalAgentConfig to the config map format. the spec uses the same fields as config.yaml,
+// except for the auth config which is typed per auth method (e.g. auth.kubernetes.identity-id instead of auth.config.identity-id) and the selectors
+func AgentConfigSpecToConfigMap(agentConfig *unstructured.Unstructured) (*util.ConfigMap, error) {
+	spec, found, err := unstructured.NestedMap(agentConfig.Object, "spec")
+	if err != nil || !found {
+		return nil, fmt.Errorf("spec is required")
+	}
+
+	// only used to resolve the config
+	delete(spec, "pod-selector")
+	delete(spec, "namespace-selector")
+
+	auth, found, err := unstructured.NestedMap(spec, "infisical", "auth")
+	if err != nil || !found {
+		return nil, fmt.Errorf("spec.infisical.auth is required")
+	}
+
+	authType, _ := auth["type"].(string)
+	authConfig, _ := auth[authType].(map[string]interface{})
+	if authConfig == nil {
+		return nil, fmt.Errorf("spec.infisical.auth.%s is required for auth type %s", authType, authType)
+	}
+
+	if err := unstructured.SetNestedMap(spec, map[string]interface{}{
+		"type":   authType,
+		"config": authConfig,
+	}, "infisical", "auth"); err != nil {
+		return nil, fmt.Errorf("failed to convert auth config: %w", err)
+	}
+
+	// json is valid yaml, so the spec is parsed the same way as config.yaml
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal spec: %w", err)
+	}
+
+	configMap, err := util.ParseConfigMapData(string(specJSON))
+	if err != nil {
+		return nil, err
+	}
+	configMap.Source = strings.ToLower(agentConfig.GetKind()) + "/" + agentConfig.GetName()
+
+	return configMap, nil
+}
@@ -0,0 +1,408 @@
+package injector
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newAgentConfig(kind string, name string, spec map[string]interface{}) unstructured.Unstructured {
+	agentConfig := unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	agentConfig.SetKind(kind)
+	agentConfig.SetName(name)
+	return agentConfig
+}
+
+func agentConfigSpec(fields map[string]interface{}) map[string]interface{} {
+	spec := map[string]interface{}{
+		"infisical": map[string]interface{}{
+			"address": "https://app.infisical.com",
+			"auth": map[string]interface{}{
+				"type": "kubernetes",
+				"kubernetes": map[string]interface{}{
+					"identity-id": "identity",
+				},
+			},
+		},
+	}
+	for key, value := range fields {
+		spec[key] = value
+	}
+	return spec
+}
+
+func TestSelectorMatches(t *testing.T) {
+	appLabels := map[string]string{"app": "api", "tier": "backend"}
+
+	tests := []struct {
+		name     string
+		selector interface{}
+		labels   map[string]string
+		want     bool
+		wantErr  string
+	}{
+		{
+			name:   "no selector",
+			labels: appLabels,
+			want:   false,
+		},
+		{
+			name:     "empty selector matches everything",
+			selector: map[string]interface{}{},
+			labels:   appLabels,
+			want:     true,
+		},
+		{
+			name:     "match labels",
+			selector: map[string]interface{}{"matchLabels": map[string]interface{}{"app": "api"}},
+			labels:   appLabels,
+			want:     true,
+		},
+		{
+			name:     "match labels mismatch",
+			selector: map[string]interface{}{"matchLabels": map[string]interface{}{"app": "web"}},
+			labels:   appLabels,
+			want:     false,
+		},
+		{
+			name: "match expressions",
+			selector: map[string]interface{}{"matchExpressions": []interface{}{
+				map[string]interface{}{"key": "tier", "operator": "In", "values": []interface{}{"backend", "worker"}},
+			}},
+			labels: appLabels,
+			want:   true,
+		},
+		{
+			name: "match expressions and labels must both match",
+			selector: map[string]interface{}{
+				"matchLabels": map[string]interface{}{"app": "api"},
+				"matchExpressions": []interface{}{
+					map[string]interface{}{"key": "tier", "operator": "NotIn", "values": []interface{}{"backend"}},
+				},
+			},
+			labels: appLabels,
+			want:   false,
+		},
+		{
+			name:     "nil labels",
+			selector: map[string]interface{}{"matchLabels": map[string]interface{}{"app": "api"}},
+			labels:   nil,
+			want:     false,
+		},
+		{
+			name:     "selector isn't an object",
+			selector: "app=api",
+			labels:   appLabels,
+			wantErr:  "invalid pod-selector of config",
+		},
+		{
+			name: "invalid operator",
+			selector: map[string]interface{}{"matchExpressions": []interface{}{
+				map[string]interface{}{"key": "tier", "operator": "Like", "values": []interface{}{"backend"}},
+			}},
+			labels:  appLabels,
+			wantErr: "invalid pod-selector of config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := agentConfigSpec(nil)
+			if tt.selector != nil {
+				spec["pod-selector"] = tt.selector
+			}
+
+			got, err := selectorMatches(newAgentConfig(util.AgentConfigKind, "config", spec), "pod-selector", tt.labels)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectAgentConfig(t *testing.T) {
+	selectAll := map[string]interface{}{"pod-selector": map[string]interface{}{}}
+
+	tests := []struct {
+		name       string
+		matches    []unstructured.Unstructured
+		kind       string
+		wantSource string
+		wantErr    string
+	}{
+		{
+			name: "no match",
+			kind: util.AgentConfigKind,
+		},
+		{
+			name:       "single match",
+			matches:    []unstructured.Unstructured{newAgentConfig(util.AgentConfigKind, "api", agentConfigSpec(selectAll))},
+			kind:       util.AgentConfigKind,
+			wantSource: "infisicalagentconfig/api",
+		},
+		{
+			name:       "single cluster match",
+			matches:    []unstructured.Unstructured{newAgentConfig(util.ClusterAgentConfigKind, "shared", agentConfigSpec(selectAll))},
+			kind:       util.ClusterAgentConfigKind,
+			wantSource: "clusterinfisicalagentconfig/shared",
+		},
+		{
+			name: "multiple matches",
+			matches: []unstructured.Unstructured{
+				newAgentConfig(util.AgentConfigKind, "api", agentConfigSpec(selectAll)),
+				newAgentConfig(util.AgentConfigKind, "all", agentConfigSpec(selectAll)),
+			},
+			kind:    util.AgentConfigKind,
+			wantErr: "multiple " + util.AgentConfigKind + " resources match the pod (api, all), set " + util.AnnotationAgentConfigResource,
+		},
+		{
+			name:    "invalid match",
+			matches: []unstructured.Unstructured{newAgentConfig(util.AgentConfigKind, "api", selectAll)},
+			kind:    util.AgentConfigKind,
+			wantErr: "invalid " + util.AgentConfigKind + " api",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectAgentConfig(tt.matches, tt.kind)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.wantSource == "" {
+				if got != nil {
+					t.Errorf("got config %s, want none", got.Source)
+				}
+				return
+			}
+			if got == nil || got.Source != tt.wantSource {
+				t.Errorf("got config %+v, want source %s", got, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestAgentConfigSpecToConfigMap(t *testing.T) {
+	tests := []struct {
+		name        string
+		agentConfig unstructured.Unstructured
+		wantErr     string
+		check       func(t *testing.T, configMap *util.ConfigMap)
+	}{
+		{
+			name: "typed auth is converted to auth config",
+			agentConfig: newAgentConfig(util.AgentConfigKind, "api", agentConfigSpec(map[string]interface{}{
+				"pod-selector":       map[string]interface{}{"matchLabels": map[string]interface{}{"app": "api"}},
+				"namespace-selector": map[string]interface{}{},
+				"templates": []interface{}{
+					map[string]interface{}{"destination-path": "/app/secrets/config", "template-content": "{{ .Value }}"},
+				},
+			})),
+			check: func(t *testing.T, configMap *util.ConfigMap) {
+				if configMap.Source != "infisicalagentconfig/api" {
+					t.Errorf("got source %s", configMap.Source)
+				}
+				if configMap.Infisical.Auth.Type != "kubernetes" || configMap.Infisical.Auth.Config["identity-id"] != "identity" {
+					t.Errorf("got auth %+v", configMap.Infisical.Auth)
+				}
+				if len(configMap.Templates) != 1 || configMap.Templates[0].DestinationPath != "/app/secrets/config" {
+					t.Errorf("got templates %+v", configMap.Templates)
+				}
+				if configMap.Hash == "" {
+					t.Errorf("hash is not set")
+				}
+			},
+		},
+		{
+			name:        "no spec",
+			agentConfig: unstructured.Unstructured{Object: map[string]interface{}{"kind": util.AgentConfigKind}},
+			wantErr:     "spec is required",
+		},
+		{
+			name:        "no auth",
+			agentConfig: newAgentConfig(util.AgentConfigKind, "api", map[string]interface{}{"infisical": map[string]interface{}{}}),
+			wantErr:     "spec.infisical.auth is required",
+		},
+		{
+			name: "auth type without config",
+			agentConfig: newAgentConfig(util.AgentConfigKind, "api", map[string]interface{}{
+				"infisical": map[string]interface{}{"auth": map[string]interface{}{"type": "ldap-auth"}},
+			}),
+			wantErr: "spec.infisical.auth.ldap-auth is required for auth type ldap-auth",
+		},
+		{
+			name: "unknown field",
+			agentConfig: newAgentConfig(util.AgentConfigKind, "api", agentConfigSpec(map[string]interface{}{
+				"unknown": true,
+			})),
+			check: func(t *testing.T, configMap *util.ConfigMap) {
+				// unknown fields are only rejected by the validating webhook, injection warns about them
+				if !slices.ContainsFunc(configMap.Warnings, func(warning string) bool { return strings.Contains(warning, "field unknown not found") }) {
+					t.Errorf("got warnings %v, want the unknown field", configMap.Warnings)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configMap, err := AgentConfigSpecToConfigMap(&tt.agentConfig)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, configMap)
+		})
+	}
+}
+
+func TestGetConfigMapPrecedence(t *testing.T) {
+	const configData = `
+infisical:
+  address: https://app.infisical.com
+  auth:
+    type: kubernetes
+    config:
+      identity-id: identity
+`
+
+	newObject := func(agentConfig unstructured.Unstructured, namespace string) *unstructured.Unstructured {
+		agentConfig.SetAPIVersion(util.AgentConfigGroup + "/" + util.AgentConfigVersion)
+		agentConfig.SetNamespace(namespace)
+		return &agentConfig
+	}
+	selectApp := func(app string) map[string]interface{} {
+		return map[string]interface{}{"matchLabels": map[string]interface{}{"app": app}}
+	}
+
+	namespaceConfig := newObject(newAgentConfig(util.AgentConfigKind, "namespace-config", agentConfigSpec(map[string]interface{}{
+		"pod-selector": selectApp("api"),
+	})), "apps")
+	namedConfig := newObject(newAgentConfig(util.AgentConfigKind, "named-config", agentConfigSpec(nil)), "apps")
+	clusterConfig := newObject(newAgentConfig(util.ClusterAgentConfigKind, "cluster-config", agentConfigSpec(map[string]interface{}{
+		"pod-selector":       map[string]interface{}{},
+		"namespace-selector": map[string]interface{}{"matchLabels": map[string]interface{}{"team": "apps"}},
+	})), "")
+
+	client := kubefake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Labels: map[string]string{"team": "apps"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "agent-config", Namespace: "apps"}, Data: map[string]string{util.AgentConfigMapDataKey: configData}},
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		util.AgentConfigGVR:        util.AgentConfigKind + "List",
+		util.ClusterAgentConfigGVR: util.ClusterAgentConfigKind + "List",
+	}, namespaceConfig, namedConfig, clusterConfig)
+
+	// without listers the cache reads from the api
+	agentConfigCache := &AgentConfigCache{client: client, dynamicClient: dynamicClient}
+
+	tests := []struct {
+		name        string
+		namespace   string
+		labels      map[string]string
+		annotations map[string]string
+		wantSource  string
+		wantErr     string
+	}{
+		{
+			name:        "config map annotation",
+			namespace:   "apps",
+			labels:      map[string]string{"app": "api"},
+			annotations: map[string]string{util.AnnotationAgentConfigMap: "agent-config"},
+			wantSource:  "configmap/agent-config",
+		},
+		{
+			name:        "resource annotation",
+			namespace:   "apps",
+			labels:      map[string]string{"app": "api"},
+			annotations: map[string]string{util.AnnotationAgentConfigResource: "named-config"},
+			wantSource:  "infisicalagentconfig/named-config",
+		},
+		{
+			name:       "namespace config before cluster config",
+			namespace:  "apps",
+			labels:     map[string]string{"app": "api"},
+			wantSource: "infisicalagentconfig/namespace-config",
+		},
+		{
+			name:       "cluster config",
+			namespace:  "apps",
+			labels:     map[string]string{"app": "web"},
+			wantSource: "clusterinfisicalagentconfig/cluster-config",
+		},
+		{
+			name:      "no match",
+			namespace: "other",
+			labels:    map[string]string{"app": "web"},
+			wantErr:   "no config map found",
+		},
+		{
+			name:      "both annotations",
+			namespace: "apps",
+			annotations: map[string]string{
+				util.AnnotationAgentConfigMap:      "agent-config",
+				util.AnnotationAgentConfigResource: "named-config",
+			},
+			wantErr: "only one of",
+		},
+		{
+			name:        "missing resource",
+			namespace:   "apps",
+			annotations: map[string]string{util.AnnotationAgentConfigResource: "missing"},
+			wantErr:     "failed to get " + util.AgentConfigKind + " missing in namespace apps",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: tt.namespace, Labels: tt.labels, Annotations: tt.annotations}}
+
+			configMap, err := GetConfigMap(client, agentConfigCache, pod, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if configMap.Source != tt.wantSource {
+				t.Errorf("got source %s, want %s", configMap.Source, tt.wantSource)
+			}
+		})
+	}
+}
@@ -1,18 +1,17 @@
 package injector
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"slices"
+	"strings"
 
 	"github.com/Infisical/infisical-agent-injector/pkg/agent"
 	"github.com/Infisical/infisical-agent-injector/pkg/util"
-	"github.com/google/uuid"
+	jsonpatch "github.com/evanphx/json-patch"
 	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
@@ -22,6 +21,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -39,10 +39,19 @@ func init() {
 }
 
 type Handler struct {
-	Client *kubernetes.Clientset
+	Client           *kubernetes.Clientset
+	DynamicClient    dynamic.Interface
+	AgentConfigCache *AgentConfigCache
 }
 
 func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.serveAdmissionReview(w, r, func(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+		return h.Mutate(req).Resp
+	})
+}
+
+// serveAdmissionReview decodes the admission review of the request and writes the response of review back in the same api version
+func (h *Handler) serveAdmissionReview(w http.ResponseWriter, r *http.Request, review func(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) {
 	if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
 		msg := fmt.Sprintf("Only application/json is supported, got: %q", contentType)
 		http.Error(w, msg, http.StatusBadRequest)
@@ -64,10 +73,7 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var (
-		mutateResp MutateResponse
-		admResp    admissionv1.AdmissionReview
-	)
+	var admResp admissionv1.AdmissionReview
 
 	admReq := unversionedAdmissionReview{}
 	admReq.SetGroupVersionKind(admissionv1.SchemeGroupVersion.WithKind("AdmissionReview"))
@@ -77,8 +83,7 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, msg, http.StatusInternalServerError)
 		return
 	} else {
-		mutateResp = h.Mutate(admReq.Request)
-		admResp.Response = mutateResp.Resp
+		admResp.Response = review(admReq.Request)
 	}
 
 	if actualAdmRevGVK == nil || *actualAdmRevGVK == (schema.GroupVersionKind{}) {
@@ -91,13 +96,13 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		errorMessage := fmt.Sprintf("error marshalling admission response: %s", err)
 		http.Error(w, errorMessage, http.StatusInternalServerError)
-		log.Printf("error on request: %s", errorMessage)
+		slog.Error("Error on request", "error", errorMessage)
 		return
 	}
 
 	if _, err := w.Write(resp); err != nil {
 		errorMessage := fmt.Sprintf("error writing response: %s", err)
-		log.Printf("error on request: %s", errorMessage)
+		slog.Error("Error on request", "error", errorMessage)
 		http.Error(w, errorMessage, http.StatusInternalServerError)
 		return
 	}
@@ -109,15 +114,7 @@ type MutateResponse struct {
 	InjectedSidecar bool
 }
 
-func randomRequestId() string {
-	hash := sha256.New()
-	hash.Write([]byte(uuid.New().String()))
-	return hex.EncodeToString(hash.Sum(nil))[:10]
-}
-
 func (h *Handler) Mutate(req *admissionv1.AdmissionRequest) MutateResponse {
-	requestId := randomRequestId()
-
 	var pod corev1.Pod
 	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
 		return admissionsApiError(req.UID, err)
@@ -128,60 +125,122 @@ func (h *Handler) Mutate(req *admissionv1.AdmissionRequest) MutateResponse {
 		UID:     req.UID,
 	}
 
-	log.Printf("[request-id=%s] New create or update mutation request received for pod: %s in namespace: %s. Checking if secrets should be injected..", requestId, pod.Name, pod.Namespace)
+	podName := pod.Name
+	if podName == "" {
+		// try using generateName if available (what controllers use as a name prefix)
+		if pod.GenerateName != "" {
+			podName = fmt.Sprintf("%s[pending-name]", pod.GenerateName)
+		} else {
+			// fall back to checking labels or just using a placeholder
+			podName = "[unnamed-pod]"
+		}
+	}
+
+	injectMode := pod.Annotations[util.InjectModeAnnotation]
+	if injectMode == "" {
+		injectMode = util.InjectModeInit
+	}
+
+	// dry run requests (e.g. kubectl apply --dry-run=server) must not have side effects, as declared by the webhook configuration
+	dryRun := req.DryRun != nil && *req.DryRun
+
+	// the uid is also in the api server audit logs
+	logger := slog.With(
+		"uid", req.UID,
+		"namespace", pod.Namespace,
+		"pod", podName,
+		"inject_mode", injectMode,
+		"dry_run", dryRun,
+	)
+
+	logger.Info("New create or update mutation request received, checking if secrets should be injected..")
 
 	if !IsInjectable(pod) {
-		log.Printf("[request-id=%s] Pod %s in namespace %s is not injectable, skipping..", requestId, pod.Name, pod.Namespace)
+		logger.Info("Pod is not injectable, skipping..")
 		return MutateResponse{
 			Resp: resp,
 		}
 	}
 
-	agentConfig, err := GetConfigMap(h.Client, pod)
+	agentConfig, err := GetConfigMap(h.Client, h.AgentConfigCache, pod, logger)
 	if err != nil {
-		log.Printf("[request-id=%s] Error getting config map for pod %s in namespace %s: %s", requestId, pod.Name, pod.Namespace, err)
+		logger.Error("Error getting config map", "error", err)
 		return admissionsApiError(req.UID, err)
 	}
 
 	if slices.Contains(util.KubeSystemNamespaces, pod.Namespace) {
 		err := fmt.Errorf("system namespace is not injectable: %s", pod.Namespace)
-		log.Printf("[request-id=%s] %s", requestId, err)
+		logger.Error("Error injecting pod", "error", err)
 		return admissionsApiError(req.UID, err)
 	}
 
-	podName := pod.Name
-	if podName == "" {
-		// try using generateName if available (what controllers use as a name prefix)
-		if pod.GenerateName != "" {
-			podName = fmt.Sprintf("%s[pending-name]", pod.GenerateName)
-		} else {
-			// fall back to checking labels or just using a placeholder
-			podName = "[unnamed-pod]"
-		}
-	}
+	logger.Info("Injecting into pod")
 
-	log.Printf("[request-id=%s] Injecting into pod: %s in namespace: %s", requestId, podName, pod.Namespace)
+	platform, err := ResolvePodPlatform(h.Client, pod, logger)
+	if err != nil {
+		logger.Error("Error resolving platform", "error", err)
+		return admissionsApiError(req.UID, err)
+	}
 
-	agent, err := agent.NewAgent(&pod, agentConfig)
+	agent, err := agent.NewAgent(&pod, agentConfig, platform, logger)
 	if err != nil {
-		log.Printf("[request-id=%s] Error creating agent for pod %s in namespace %s: %s", requestId, podName, pod.Namespace, err)
+		logger.Error("Error creating agent", "error", err)
 		return admissionsApiError(req.UID, err)
 	}
 
 	err = agent.ValidateConfigMap()
 	if err != nil {
-		log.Printf("[request-id=%s] Error validating config map for pod %s in namespace %s: %s", requestId, podName, pod.Namespace, err)
+		logger.Error("Error validating config map", "error", err)
+		return admissionsApiError(req.UID, err)
+	}
+
+	pullSecrets, err := agent.ImagePullSecrets()
+	if err != nil {
+		logger.Error("Error getting image pull secrets", "error", err)
+		return admissionsApiError(req.UID, err)
+	}
+
+	if err := ValidateImagePullSecrets(h.Client, pod.Namespace, pullSecrets); err != nil {
+		logger.Error("Error validating image pull secrets", "error", err)
 		return admissionsApiError(req.UID, err)
 	}
 
 	patch, err := agent.PatchPod()
 	if err != nil {
-		log.Printf("[request-id=%s] Error patching pod %s in namespace %s: %s", requestId, podName, pod.Namespace, err)
+		logger.Error("Error patching pod", "error", err)
+		return admissionsApiError(req.UID, err)
+	}
+
+	// the pod would be rejected by the pod security admission plugin later with a less helpful error
+	patch, podSecurityWarnings, err := EnforcePodSecurity(h.AgentConfigCache, pod, platform, req.Object.Raw, patch)
+	if err != nil {
+		logger.Error("Error enforcing pod security", "error", err)
+		return admissionsApiError(req.UID, err)
+	}
+
+	if dryRun {
+		logger.Info("Dry run, skipping creation of kubernetes secret sinks")
+	} else if err := EnsureSecretSinks(h.Client, pod, agent.SecretSyncTargets()); err != nil {
+		logger.Error("Error creating kubernetes secret sinks", "error", err)
 		return admissionsApiError(req.UID, err)
 	}
 
-	log.Printf("[request-id=%s] Successfully patched pod: %s in namespace: %s", requestId, podName, pod.Namespace)
+	// audit metadata never blocks the injection
+	auditAnnotations, err := buildAuditAnnotations(pod, req.Object.Raw, patch, injectMode, agentConfig.Source)
+	if err != nil {
+		logger.Warn("Failed to build audit annotations, continuing without them", "error", err)
+	}
+	if len(podSecurityWarnings) > 0 {
+		logger.Info("Hardened injected containers for pod security", "changes", podSecurityWarnings)
+		if auditAnnotations != nil {
+			auditAnnotations["pod-security-hardened"] = "true"
+		}
+	}
+
+	logger.Info("Successfully patched pod", "injected_containers", auditAnnotations["injected-containers"])
 
+	resp.AuditAnnotations = auditAnnotations
+	resp.Warnings = append(agent.Warnings(), podSecurityWarnings...)
 	resp.Patch = patch
 	patchType := admissionv1.PatchTypeJSONPatch
 	resp.PatchType = &patchType
@@ -193,6 +252,31 @@ func (h *Handler) Mutate(req *admissionv1.AdmissionRequest) MutateResponse {
 	}
 }
 
+// buildAuditAnnotations describes what was injected. the api server prefixes the keys with the webhook name in the audit log
+func buildAuditAnnotations(pod corev1.Pod, rawPod []byte, patch []byte, injectMode string, configSource string) (map[string]string, error) {
+	podPatch, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode pod patch: %w", err)
+	}
+
+	mutatedPod, err := applyPodPatch(rawPod, podPatch)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []string
+	for _, injected := range injectedContainers(pod, mutatedPod) {
+		containers = append(containers, fmt.Sprintf("%s=%s", injected.container.Name, injected.container.Image))
+	}
+
+	return map[string]string{
+		"inject-mode":         injectMode,
+		"config-source":       configSource,
+		"config-hash":         mutatedPod.Annotations[util.AnnotationAgentConfigHash],
+		"injected-containers": strings.Join(containers, ","),
+	}, nil
+}
+
 func admissionsApiError(reqUid types.UID, e error) MutateResponse {
 	return MutateResponse{
 		Resp: &admissionv1.AdmissionResponse{
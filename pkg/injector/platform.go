@@ -0,0 +1,86 @@
+package injector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ResolvePodPlatform returns the os and architectures the pod can run on. the os is resolved in order of:
+// the agent-os annotation, the node the pod is assigned to, the pod scheduling requirements, the pod runtime class,
+// and lastly the pod scheduling preferences.
+func ResolvePodPlatform(client kubernetes.Interface, pod corev1.Pod, logger *slog.Logger) (util.PodPlatform, error) {
+	platform := util.PodPlatform{
+		Architectures: util.GetPodArchitectures(&pod),
+	}
+
+	osOverride := pod.Annotations[util.AnnotationAgentOS]
+	if osOverride != "" && osOverride != util.OSLinux && osOverride != util.OSWindows {
+		return util.PodPlatform{}, fmt.Errorf("invalid %s annotation %s. please use %s or %s", util.AnnotationAgentOS, osOverride, util.OSLinux, util.OSWindows)
+	}
+
+	if pod.Spec.NodeName != "" {
+		node, err := client.CoreV1().Nodes().Get(context.TODO(), pod.Spec.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return util.PodPlatform{}, fmt.Errorf("failed to get node %s: %w", pod.Spec.NodeName, err)
+		}
+
+		if nodeArch := node.Labels[util.LabelArch]; nodeArch != "" {
+			platform.Architectures = []string{nodeArch}
+		}
+
+		if nodeOS := node.Labels[util.LabelOS]; nodeOS != "" && osOverride == "" {
+			platform.OS = nodeOS
+			return platform, nil
+		}
+	}
+
+	if osOverride != "" {
+		platform.OS = osOverride
+		return platform, nil
+	}
+
+	if util.IsWindowsPod(&pod) {
+		platform.OS = util.OSWindows
+		return platform, nil
+	}
+
+	// the runtime class admission plugin usually merges the runtime class node selector into the pod before we see it, but it can be disabled
+	if pod.Spec.RuntimeClassName != nil && *pod.Spec.RuntimeClassName != "" {
+		runtimeClass, err := client.NodeV1().RuntimeClasses().Get(context.TODO(), *pod.Spec.RuntimeClassName, metav1.GetOptions{})
+		if err != nil {
+			return util.PodPlatform{}, fmt.Errorf("failed to get runtime class %s: %w", *pod.Spec.RuntimeClassName, err)
+		}
+
+		if scheduling := runtimeClass.Scheduling; scheduling != nil {
+			if len(platform.Architectures) == 0 {
+				for _, label := range []string{util.LabelArch, util.LabelBetaArch} {
+					if arch := scheduling.NodeSelector[label]; arch != "" {
+						platform.Architectures = []string{arch}
+						break
+					}
+				}
+			}
+
+			if slices.Contains([]string{scheduling.NodeSelector[util.LabelOS], scheduling.NodeSelector[util.LabelBetaOS]}, util.OSWindows) {
+				platform.OS = util.OSWindows
+				return platform, nil
+			}
+		}
+	}
+
+	if util.PrefersWindowsNodes(&pod) {
+		logger.Info("Pod prefers windows nodes, treating it as a windows pod", "override_annotation", util.AnnotationAgentOS)
+		platform.OS = util.OSWindows
+		return platform, nil
+	}
+
+	platform.OS = util.OSLinux
+	return platform, nil
+}
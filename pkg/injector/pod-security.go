@@ -0,0 +1,319 @@
+package injector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/agent"
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+// capabilities the baseline level allows to be added
+var baselineCapabilities = []corev1.Capability{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD", "NET_BIND_SERVICE",
+	"SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+}
+
+type podSecurityViolation struct {
+	container string
+	path      string
+	reason    string
+}
+
+// injectedContainer is a container added by the injector, with the json patch path of the container in the mutated pod
+type injectedContainer struct {
+	container corev1.Container
+	path      string
+}
+
+// EnforcePodSecurity checks the containers added by the patch against the pod security level enforced on the namespace.
+// depending on the pod security annotation, violating containers are hardened by appending to the patch, or an error explaining the violations is returned.
+// the hardened fields are returned as warnings, so the change of the security context isn't silent.
+// only the injected containers are checked, the app containers are left to the pod security admission plugin.
+// platform is the one the agent containers were built for, as resolved by ResolvePodPlatform.
+func EnforcePodSecurity(agentConfigCache *AgentConfigCache, pod corev1.Pod, platform util.PodPlatform, rawPod []byte, patch []byte) ([]byte, []string, error) {
+	mode := pod.Annotations[util.AnnotationPodSecurity]
+	if mode == "" {
+		mode = util.PodSecurityModeHarden
+	}
+	if mode != util.PodSecurityModeHarden && mode != util.PodSecurityModeFail {
+		return nil, nil, fmt.Errorf("invalid %s annotation %s. please use %s or %s", util.AnnotationPodSecurity, mode, util.PodSecurityModeHarden, util.PodSecurityModeFail)
+	}
+
+	namespace, err := agentConfigCache.getNamespace(pod.Namespace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get namespace %s: %w", pod.Namespace, err)
+	}
+
+	level := namespace.Labels[util.LabelPodSecurityEnforce]
+	if level == "" || level == util.PodSecurityLevelPrivileged {
+		return patch, nil, nil
+	}
+	if level != util.PodSecurityLevelBaseline && level != util.PodSecurityLevelRestricted {
+		return nil, nil, fmt.Errorf("unknown pod security level %s on namespace %s", level, pod.Namespace)
+	}
+
+	podPatch, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode pod patch: %w", err)
+	}
+
+	mutatedPod, err := applyPodPatch(rawPod, podPatch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	containers := injectedContainers(pod, mutatedPod)
+
+	violations := evaluatePodSecurity(mutatedPod, containers, level)
+	if len(violations) == 0 {
+		return patch, nil, nil
+	}
+
+	if mode == util.PodSecurityModeFail {
+		return nil, nil, podSecurityError(pod.Namespace, level, violations, false)
+	}
+
+	for _, injected := range containers {
+		securityContext := hardenSecurityContext(mutatedPod, platform, injected.container, level)
+		podPatch = append(podPatch, agent.AddOp(injected.path+"/securityContext", securityContext))
+	}
+
+	// some violations can't be hardened without changing the user the agent explicitly runs as
+	mutatedPod, err = applyPodPatch(rawPod, podPatch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if violations := evaluatePodSecurity(mutatedPod, injectedContainers(pod, mutatedPod), level); len(violations) > 0 {
+		return nil, nil, podSecurityError(pod.Namespace, level, violations, true)
+	}
+
+	hardenedPatch, err := json.Marshal(podPatch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal hardened pod patch: %w", err)
+	}
+
+	var warnings []string
+	for _, violation := range violations {
+		warnings = append(warnings, fmt.Sprintf("container %s: changed %s, which %s under the %s pod security level of namespace %s",
+			violation.container, violation.path, violation.reason, level, pod.Namespace))
+	}
+
+	return hardenedPatch, warnings, nil
+}
+
+func applyPodPatch(rawPod []byte, podPatch jsonpatch.Patch) (*corev1.Pod, error) {
+	patchedPod, err := podPatch.Apply(rawPod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply pod patch: %w", err)
+	}
+
+	var mutatedPod corev1.Pod
+	if err := json.Unmarshal(patchedPod, &mutatedPod); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal patched pod: %w", err)
+	}
+
+	return &mutatedPod, nil
+}
+
+func injectedContainers(original corev1.Pod, mutated *corev1.Pod) []injectedContainer {
+	var originalNames []string
+	for _, container := range append(original.Spec.InitContainers, original.Spec.Containers...) {
+		originalNames = append(originalNames, container.Name)
+	}
+
+	var containers []injectedContainer
+	for i, container := range mutated.Spec.InitContainers {
+		if !slices.Contains(originalNames, container.Name) {
+			containers = append(containers, injectedContainer{container: container, path: fmt.Sprintf("/spec/initContainers/%d", i)})
+		}
+	}
+	for i, container := range mutated.Spec.Containers {
+		if !slices.Contains(originalNames, container.Name) {
+			containers = append(containers, injectedContainer{container: container, path: fmt.Sprintf("/spec/containers/%d", i)})
+		}
+	}
+
+	return containers
+}
+
+func evaluatePodSecurity(pod *corev1.Pod, containers []injectedContainer, level string) []podSecurityViolation {
+	var violations []podSecurityViolation
+
+	podSecurityContext := pod.Spec.SecurityContext
+	if podSecurityContext == nil {
+		podSecurityContext = &corev1.PodSecurityContext{}
+	}
+
+	// the pod security admission plugin only skips the linux only checks of the restricted level for pods that set spec.os to windows
+	isWindows := isWindowsPodOS(pod)
+
+	for _, injected := range containers {
+		container := injected.container
+		securityContext := container.SecurityContext
+		if securityContext == nil {
+			securityContext = &corev1.SecurityContext{}
+		}
+
+		addViolation := func(path string, reason string) {
+			violations = append(violations, podSecurityViolation{container: container.Name, path: path, reason: reason})
+		}
+
+		// baseline
+		if securityContext.Privileged != nil && *securityContext.Privileged {
+			addViolation("securityContext.privileged", "must not be true")
+		}
+
+		if securityContext.Capabilities != nil {
+			for _, capability := range securityContext.Capabilities.Add {
+				if !slices.Contains(baselineCapabilities, capability) {
+					addViolation("securityContext.capabilities.add", fmt.Sprintf("must not add %s", capability))
+				}
+			}
+		}
+
+		seccompProfile := podSecurityContext.SeccompProfile
+		if securityContext.SeccompProfile != nil {
+			seccompProfile = securityContext.SeccompProfile
+		}
+		if seccompProfile != nil && seccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+			addViolation("securityContext.seccompProfile.type", "must not be Unconfined")
+		}
+
+		if level != util.PodSecurityLevelRestricted {
+			continue
+		}
+
+		// restricted
+		runAsNonRoot := podSecurityContext.RunAsNonRoot
+		if securityContext.RunAsNonRoot != nil {
+			runAsNonRoot = securityContext.RunAsNonRoot
+		}
+		if runAsNonRoot == nil || !*runAsNonRoot {
+			addViolation("securityContext.runAsNonRoot", "must be true")
+		}
+
+		runAsUser := podSecurityContext.RunAsUser
+		if securityContext.RunAsUser != nil {
+			runAsUser = securityContext.RunAsUser
+		}
+		if runAsUser != nil && *runAsUser == 0 {
+			addViolation("securityContext.runAsUser", fmt.Sprintf("must not be 0, set %s to a non-root user", util.AnnotationSecurityContextRunAsUser))
+		}
+
+		if isWindows {
+			continue
+		}
+
+		if securityContext.AllowPrivilegeEscalation == nil || *securityContext.AllowPrivilegeEscalation {
+			addViolation("securityContext.allowPrivilegeEscalation", "must be false")
+		}
+
+		if securityContext.Capabilities == nil || !slices.Contains(securityContext.Capabilities.Drop, "ALL") {
+			addViolation("securityContext.capabilities.drop", "must include ALL")
+		}
+		if securityContext.Capabilities != nil {
+			for _, capability := range securityContext.Capabilities.Add {
+				if capability != "NET_BIND_SERVICE" && slices.Contains(baselineCapabilities, capability) {
+					addViolation("securityContext.capabilities.add", fmt.Sprintf("must not add %s", capability))
+				}
+			}
+		}
+
+		if seccompProfile == nil {
+			addViolation("securityContext.seccompProfile.type", "must be RuntimeDefault or Localhost")
+		}
+	}
+
+	return violations
+}
+
+// hardenSecurityContext returns the security context of the container with the fields the level requires set to compliant values.
+// an explicit root user is kept, so the violation is reported instead of silently changing the user the agent runs as
+func hardenSecurityContext(pod *corev1.Pod, platform util.PodPlatform, container corev1.Container, level string) *corev1.SecurityContext {
+	securityContext := &corev1.SecurityContext{}
+	if container.SecurityContext != nil {
+		securityContext = container.SecurityContext.DeepCopy()
+	}
+
+	podSecurityContext := pod.Spec.SecurityContext
+	if podSecurityContext == nil {
+		podSecurityContext = &corev1.PodSecurityContext{}
+	}
+
+	securityContext.Privileged = nil
+	if securityContext.Capabilities != nil {
+		securityContext.Capabilities.Add = slices.DeleteFunc(securityContext.Capabilities.Add, func(capability corev1.Capability) bool {
+			return !slices.Contains(baselineCapabilities, capability) || (level == util.PodSecurityLevelRestricted && capability != "NET_BIND_SERVICE")
+		})
+	}
+	if securityContext.SeccompProfile != nil && securityContext.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+		securityContext.SeccompProfile = nil
+	}
+
+	if level != util.PodSecurityLevelRestricted {
+		return securityContext
+	}
+
+	runAsUser := podSecurityContext.RunAsUser
+	if securityContext.RunAsUser != nil {
+		runAsUser = securityContext.RunAsUser
+	}
+	// the agent image may default to root, so we pick a user instead of relying on the image.
+	// in inherit mode the user is left to the platform (e.g. openshift assigns one from the namespace range), runAsNonRoot makes the kubelet verify it
+	inherit := strings.EqualFold(pod.Annotations[util.AnnotationSetSecurityContext], util.SecurityContextModeInherit)
+	// windows containers run as a user name, not a uid
+	if runAsUser == nil && platform.OS != util.OSWindows && !inherit {
+		securityContext.RunAsUser = pointer.Int64(util.DefaultSecurityContextRunAsUser)
+		if securityContext.RunAsGroup == nil && podSecurityContext.RunAsGroup == nil {
+			securityContext.RunAsGroup = pointer.Int64(util.DefaultSecurityContextRunAsGroup)
+		}
+	}
+	securityContext.RunAsNonRoot = pointer.Bool(true)
+
+	// windows agents resolved from the node or scheduling preferences still get the linux only fields, the plugin requires them unless spec.os is windows
+	if isWindowsPodOS(pod) {
+		return securityContext
+	}
+
+	securityContext.AllowPrivilegeEscalation = pointer.Bool(false)
+
+	if securityContext.Capabilities == nil {
+		securityContext.Capabilities = &corev1.Capabilities{}
+	}
+	if !slices.Contains(securityContext.Capabilities.Drop, "ALL") {
+		securityContext.Capabilities.Drop = append(securityContext.Capabilities.Drop, "ALL")
+	}
+
+	if securityContext.SeccompProfile == nil && (podSecurityContext.SeccompProfile == nil || podSecurityContext.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined) {
+		securityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	}
+
+	return securityContext
+}
+
+func isWindowsPodOS(pod *corev1.Pod) bool {
+	return pod.Spec.OS != nil && pod.Spec.OS.Name == corev1.Windows
+}
+
+func podSecurityError(namespace string, level string, violations []podSecurityViolation, hardened bool) error {
+	var reasons []string
+	for _, violation := range violations {
+		reasons = append(reasons, fmt.Sprintf("container %s: %s %s", violation.container, violation.path, violation.reason))
+	}
+
+	message := fmt.Sprintf("injected containers would violate the %s pod security level enforced on namespace %s (%s). set %s to true or %s",
+		level, namespace, strings.Join(reasons, "; "), util.AnnotationSetSecurityContext, util.SecurityContextModeInherit)
+
+	if hardened {
+		return errors.New(message)
+	}
+	return fmt.Errorf("%s, or set %s to %s to let the injector harden them", message, util.AnnotationPodSecurity, util.PodSecurityModeHarden)
+}
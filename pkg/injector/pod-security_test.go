@@ -0,0 +1,180 @@
+package injector
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/agent"
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEnforcePodSecurity(t *testing.T) {
+	rootUser := int64(0)
+
+	tests := []struct {
+		name            string
+		level           string
+		mode            string
+		securityContext *corev1.SecurityContext
+		wantHardened    bool
+		wantWarnings    []string
+		wantErr         string
+	}{
+		{
+			name:  "no level",
+			level: "",
+		},
+		{
+			name:  "privileged",
+			level: util.PodSecurityLevelPrivileged,
+		},
+		{
+			name:            "compliant container",
+			level:           util.PodSecurityLevelBaseline,
+			securityContext: &corev1.SecurityContext{},
+		},
+		{
+			name:            "harden by default",
+			level:           util.PodSecurityLevelBaseline,
+			securityContext: &corev1.SecurityContext{Privileged: boolPointer(true)},
+			wantHardened:    true,
+			wantWarnings:    []string{"container infisical-agent-init: changed securityContext.privileged, which must not be true under the baseline pod security level of namespace apps"},
+		},
+		{
+			name:         "harden restricted",
+			level:        util.PodSecurityLevelRestricted,
+			mode:         util.PodSecurityModeHarden,
+			wantHardened: true,
+			wantWarnings: []string{
+				"container infisical-agent-init: changed securityContext.runAsNonRoot, which must be true under the restricted pod security level of namespace apps",
+				"container infisical-agent-init: changed securityContext.allowPrivilegeEscalation, which must be false under the restricted pod security level of namespace apps",
+				"container infisical-agent-init: changed securityContext.capabilities.drop, which must include ALL under the restricted pod security level of namespace apps",
+				"container infisical-agent-init: changed securityContext.seccompProfile.type, which must be RuntimeDefault or Localhost under the restricted pod security level of namespace apps",
+			},
+		},
+		{
+			name:            "harden keeps an explicit root user",
+			level:           util.PodSecurityLevelRestricted,
+			securityContext: &corev1.SecurityContext{RunAsUser: &rootUser},
+			wantErr:         "securityContext.runAsUser must not be 0",
+		},
+		{
+			name:            "fail",
+			level:           util.PodSecurityLevelBaseline,
+			mode:            util.PodSecurityModeFail,
+			securityContext: &corev1.SecurityContext{Privileged: boolPointer(true)},
+			wantErr:         "to let the injector harden them",
+		},
+		{
+			name:    "invalid mode",
+			level:   util.PodSecurityLevelBaseline,
+			mode:    "ignore",
+			wantErr: "invalid org.infisical.com/agent-pod-security annotation ignore",
+		},
+		{
+			name:    "unknown level",
+			level:   "strict",
+			wantErr: "unknown pod security level strict on namespace apps",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+			if tt.level != "" {
+				namespace.Labels = map[string]string{util.LabelPodSecurityEnforce: tt.level}
+			}
+			agentConfigCache := &AgentConfigCache{client: kubefake.NewSimpleClientset(namespace)}
+
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps", Annotations: map[string]string{}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+			if tt.mode != "" {
+				pod.Annotations[util.AnnotationPodSecurity] = tt.mode
+			}
+			rawPod, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatalf("failed to marshal pod: %v", err)
+			}
+
+			patch, err := json.Marshal(jsonpatch.Patch{
+				agent.AddOp("/spec/initContainers", []corev1.Container{{Name: util.InitContainerName, Image: "infisical/cli", SecurityContext: tt.securityContext}}),
+			})
+			if err != nil {
+				t.Fatalf("failed to marshal patch: %v", err)
+			}
+
+			got, warnings, err := EnforcePodSecurity(agentConfigCache, pod, util.PodPlatform{OS: util.OSLinux}, rawPod, patch)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if hardened := string(got) != string(patch); hardened != tt.wantHardened {
+				t.Errorf("got hardened %v, want %v: %s", hardened, tt.wantHardened, got)
+			}
+			if strings.Join(warnings, "\n") != strings.Join(tt.wantWarnings, "\n") {
+				t.Errorf("got warnings %q, want %q", warnings, tt.wantWarnings)
+			}
+			if !tt.wantHardened {
+				return
+			}
+
+			podPatch, err := jsonpatch.DecodePatch(got)
+			if err != nil {
+				t.Fatalf("failed to decode patch: %v", err)
+			}
+			mutatedPod, err := applyPodPatch(rawPod, podPatch)
+			if err != nil {
+				t.Fatalf("failed to apply patch: %v", err)
+			}
+			if violations := evaluatePodSecurity(mutatedPod, injectedContainers(pod, mutatedPod), tt.level); len(violations) > 0 {
+				t.Errorf("hardened pod still has violations: %+v", violations)
+			}
+		})
+	}
+}
+
+func TestHardenSecurityContextPlatform(t *testing.T) {
+	tests := []struct {
+		name              string
+		platform          string
+		podOS             *corev1.PodOS
+		wantRunAsUser     bool
+		wantLinuxSettings bool
+	}{
+		{name: "linux", platform: util.OSLinux, wantRunAsUser: true, wantLinuxSettings: true},
+		{name: "windows from scheduling", platform: util.OSWindows, wantRunAsUser: false, wantLinuxSettings: true},
+		{name: "windows from spec.os", platform: util.OSWindows, podOS: &corev1.PodOS{Name: corev1.Windows}, wantRunAsUser: false, wantLinuxSettings: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{OS: tt.podOS}}
+			container := corev1.Container{Name: util.InitContainerName}
+
+			securityContext := hardenSecurityContext(pod, util.PodPlatform{OS: tt.platform}, container, util.PodSecurityLevelRestricted)
+
+			if gotRunAsUser := securityContext.RunAsUser != nil; gotRunAsUser != tt.wantRunAsUser {
+				t.Errorf("got run as user %v, want set %v", securityContext.RunAsUser, tt.wantRunAsUser)
+			}
+			if gotLinuxSettings := securityContext.AllowPrivilegeEscalation != nil; gotLinuxSettings != tt.wantLinuxSettings {
+				t.Errorf("got allow privilege escalation %v, want set %v", securityContext.AllowPrivilegeEscalation, tt.wantLinuxSettings)
+			}
+			if securityContext.RunAsNonRoot == nil || !*securityContext.RunAsNonRoot {
+				t.Errorf("got run as non root %v, want true", securityContext.RunAsNonRoot)
+			}
+		})
+	}
+}
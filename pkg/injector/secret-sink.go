@@ -0,0 +1,281 @@
+package injector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EnsureSecretSinks creates the kubernetes secrets the secret sync container writes to, and a role that allows the pod service account to update them.
+// the secrets are owned by the pod's workload so they get garbage collected with it. pods without a workload are not known by uid at admission time,
+// so the sync container adds the pod as owner of the secret itself. the role and role binding are owned by the secrets they grant access to,
+// so they are garbage collected once the last of them is gone, also for bare pods.
+func EnsureSecretSinks(client kubernetes.Interface, pod corev1.Pod, targets []util.SecretSyncTarget) error {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	ctx := context.TODO()
+
+	owner, err := getWorkloadOwnerReference(ctx, client, pod)
+	if err != nil {
+		return err
+	}
+
+	var secretNames []string
+	for _, target := range targets {
+		if !slices.Contains(secretNames, target.SecretName) {
+			secretNames = append(secretNames, target.SecretName)
+		}
+	}
+
+	var secrets []*corev1.Secret
+	for _, secretName := range secretNames {
+		secret, err := ensureSecret(ctx, client, pod.Namespace, secretName, owner)
+		if err != nil {
+			return err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+
+	return ensureSecretSyncRole(ctx, client, pod.Namespace, serviceAccountName, secrets)
+}
+
+func getWorkloadOwnerReference(ctx context.Context, client kubernetes.Interface, pod corev1.Pod) (*metav1.OwnerReference, error) {
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return nil, nil
+	}
+
+	if owner.Kind == "ReplicaSet" {
+		replicaSet, err := client.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get replica set %s in namespace %s: %w", owner.Name, pod.Namespace, err)
+		}
+
+		// replica sets are replaced on every rollout, so the deployment owns the secret
+		if deploymentOwner := metav1.GetControllerOf(replicaSet); deploymentOwner != nil && deploymentOwner.Kind == "Deployment" {
+			owner = deploymentOwner
+		}
+	}
+
+	return &metav1.OwnerReference{
+		APIVersion: owner.APIVersion,
+		Kind:       owner.Kind,
+		Name:       owner.Name,
+		UID:        owner.UID,
+	}, nil
+}
+
+func appendOwnerReference(ownerReferences []metav1.OwnerReference, owner *metav1.OwnerReference) ([]metav1.OwnerReference, bool) {
+	if owner == nil || slices.ContainsFunc(ownerReferences, func(ownerReference metav1.OwnerReference) bool {
+		return ownerReference.Kind == owner.Kind && ownerReference.Name == owner.Name && ownerReference.UID == owner.UID
+	}) {
+		return ownerReferences, false
+	}
+
+	return append(ownerReferences, *owner), true
+}
+
+func secretOwnerReference(secret *corev1.Secret) *metav1.OwnerReference {
+	return &metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Name:       secret.Name,
+		UID:        secret.UID,
+	}
+}
+
+func managedObjectMeta(name string, namespace string, owners ...*metav1.OwnerReference) metav1.ObjectMeta {
+	objectMeta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		Labels: map[string]string{
+			util.LabelManagedBy: util.LabelManagedByValue,
+		},
+	}
+
+	for _, owner := range owners {
+		objectMeta.OwnerReferences, _ = appendOwnerReference(objectMeta.OwnerReferences, owner)
+	}
+
+	return objectMeta
+}
+
+func ensureSecret(ctx context.Context, client kubernetes.Interface, namespace string, name string, owner *metav1.OwnerReference) (*corev1.Secret, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret, err = client.CoreV1().Secrets(namespace).Create(ctx, &corev1.Secret{
+			ObjectMeta: managedObjectMeta(name, namespace, owner),
+			Type:       corev1.SecretTypeOpaque,
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create secret %s in namespace %s: %w", name, namespace, err)
+		}
+		return secret, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s in namespace %s: %w", name, namespace, err)
+	}
+
+	// never overwrite secrets we didn't create
+	if secret.Labels[util.LabelManagedBy] != util.LabelManagedByValue {
+		return nil, fmt.Errorf("secret %s in namespace %s already exists and is not managed by the agent injector", name, namespace)
+	}
+
+	ownerReferences, updated := appendOwnerReference(secret.OwnerReferences, owner)
+	if !updated {
+		return secret, nil
+	}
+
+	secret.OwnerReferences = ownerReferences
+	secret, err = client.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update secret %s in namespace %s: %w", name, namespace, err)
+	}
+
+	return secret, nil
+}
+
+func ensureSecretSyncRole(ctx context.Context, client kubernetes.Interface, namespace string, serviceAccountName string, secrets []*corev1.Secret) error {
+	name := fmt.Sprintf("infisical-secret-sync-%s", serviceAccountName)
+
+	var secretNames []string
+	var owners []*metav1.OwnerReference
+	for _, secret := range secrets {
+		secretNames = append(secretNames, secret.Name)
+		owners = append(owners, secretOwnerReference(secret))
+	}
+
+	role, err := client.RbacV1().Roles(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		role = &rbacv1.Role{
+			ObjectMeta: managedObjectMeta(name, namespace, owners...),
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups:     []string{""},
+					Resources:     []string{"secrets"},
+					ResourceNames: secretNames,
+					Verbs:         []string{"get", "update"},
+				},
+			},
+		}
+		if _, err := client.RbacV1().Roles(namespace).Create(ctx, role, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create role %s in namespace %s: %w", name, namespace, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get role %s in namespace %s: %w", name, namespace, err)
+	} else {
+		// never widen roles we didn't create
+		if role.Labels[util.LabelManagedBy] != util.LabelManagedByValue {
+			return fmt.Errorf("role %s in namespace %s already exists and is not managed by the agent injector", name, namespace)
+		}
+
+		if len(role.Rules) == 0 {
+			role.Rules = []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get", "update"}}}
+		}
+
+		// drop the secrets that were garbage collected since, so a secret created later with the same name isn't writable by the pods
+		resourceNames, err := existingSecretNames(ctx, client, namespace, role.Rules[0].ResourceNames, secretNames)
+		if err != nil {
+			return err
+		}
+		for _, secretName := range secretNames {
+			if !slices.Contains(resourceNames, secretName) {
+				resourceNames = append(resourceNames, secretName)
+			}
+		}
+		updated := !slices.Equal(resourceNames, role.Rules[0].ResourceNames)
+		role.Rules[0].ResourceNames = resourceNames
+
+		for _, owner := range owners {
+			var ownerUpdated bool
+			role.OwnerReferences, ownerUpdated = appendOwnerReference(role.OwnerReferences, owner)
+			updated = updated || ownerUpdated
+		}
+
+		if updated {
+			if _, err := client.RbacV1().Roles(namespace).Update(ctx, role, metav1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("failed to update role %s in namespace %s: %w", name, namespace, err)
+			}
+		}
+	}
+
+	roleBinding, err := client.RbacV1().RoleBindings(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		roleBinding = &rbacv1.RoleBinding{
+			ObjectMeta: managedObjectMeta(name, namespace, owners...),
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "Role",
+				Name:     name,
+			},
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      rbacv1.ServiceAccountKind,
+					Name:      serviceAccountName,
+					Namespace: namespace,
+				},
+			},
+		}
+		if _, err := client.RbacV1().RoleBindings(namespace).Create(ctx, roleBinding, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create role binding %s in namespace %s: %w", name, namespace, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get role binding %s in namespace %s: %w", name, namespace, err)
+	}
+
+	if roleBinding.Labels[util.LabelManagedBy] != util.LabelManagedByValue {
+		return fmt.Errorf("role binding %s in namespace %s already exists and is not managed by the agent injector", name, namespace)
+	}
+
+	updated := false
+	for _, owner := range owners {
+		var ownerUpdated bool
+		roleBinding.OwnerReferences, ownerUpdated = appendOwnerReference(roleBinding.OwnerReferences, owner)
+		updated = updated || ownerUpdated
+	}
+	if updated {
+		if _, err := client.RbacV1().RoleBindings(namespace).Update(ctx, roleBinding, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update role binding %s in namespace %s: %w", name, namespace, err)
+		}
+	}
+
+	return nil
+}
+
+// existingSecretNames returns the names that still have a secret, names in skip are assumed to exist
+func existingSecretNames(ctx context.Context, client kubernetes.Interface, namespace string, names []string, skip []string) ([]string, error) {
+	var existing []string
+	for _, name := range names {
+		if slices.Contains(skip, name) {
+			existing = append(existing, name)
+			continue
+		}
+
+		_, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret %s in namespace %s: %w", name, namespace, err)
+		}
+		existing = append(existing, name)
+	}
+
+	return existing, nil
+}
@@ -0,0 +1,196 @@
+package injector
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEnsureSecretSinks(t *testing.T) {
+	managed := map[string]string{util.LabelManagedBy: util.LabelManagedByValue}
+	roleName := "infisical-secret-sync-app"
+
+	barePod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "apps"},
+		Spec:       corev1.PodSpec{ServiceAccountName: "app"},
+	}
+
+	deploymentPod := *barePod.DeepCopy()
+	deploymentPod.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-5d4f", UID: "replica-set-uid", Controller: boolPointer(true)},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-5d4f",
+			Namespace: "apps",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "deployment-uid", Controller: boolPointer(true)},
+			},
+		},
+	}
+
+	tests := []struct {
+		name              string
+		pod               corev1.Pod
+		objects           []runtime.Object
+		targets           []string
+		wantSecretOwner   string
+		wantResourceNames []string
+		wantRoleOwners    []string
+		wantBindingOwners []string // Defaults to the role owners
+		wantErr           string
+	}{
+		{
+			name:              "bare pod",
+			pod:               barePod,
+			targets:           []string{"db", "api", "db"},
+			wantResourceNames: []string{"db", "api"},
+			wantRoleOwners:    []string{"Secret/db", "Secret/api"},
+		},
+		{
+			name:              "deployment pod",
+			pod:               deploymentPod,
+			objects:           []runtime.Object{replicaSet},
+			targets:           []string{"db"},
+			wantSecretOwner:   "Deployment/app",
+			wantResourceNames: []string{"db"},
+			wantRoleOwners:    []string{"Secret/db"},
+		},
+		{
+			name: "existing role drops deleted secrets",
+			pod:  barePod,
+			objects: []runtime.Object{
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "apps", Labels: managed, UID: "cache-uid"}},
+				&rbacv1.Role{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            roleName,
+						Namespace:       "apps",
+						Labels:          managed,
+						OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "Secret", Name: "cache", UID: "cache-uid"}},
+					},
+					Rules: []rbacv1.PolicyRule{
+						{APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"cache", "deleted"}, Verbs: []string{"get", "update"}},
+					},
+				},
+			},
+			targets:           []string{"db"},
+			wantResourceNames: []string{"cache", "db"},
+			wantRoleOwners:    []string{"Secret/cache", "Secret/db"},
+			wantBindingOwners: []string{"Secret/db"},
+		},
+		{
+			name: "unmanaged secret",
+			pod:  barePod,
+			objects: []runtime.Object{
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "apps"}},
+			},
+			targets: []string{"db"},
+			wantErr: "is not managed by the agent injector",
+		},
+		{
+			name: "unmanaged role",
+			pod:  barePod,
+			objects: []runtime.Object{
+				&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: roleName, Namespace: "apps"}},
+			},
+			targets: []string{"db"},
+			wantErr: "role infisical-secret-sync-app in namespace apps already exists and is not managed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := kubefake.NewSimpleClientset(tt.objects...)
+
+			var targets []util.SecretSyncTarget
+			for _, secretName := range tt.targets {
+				targets = append(targets, util.SecretSyncTarget{SecretName: secretName, Format: "file", Path: "/shared/" + secretName})
+			}
+
+			err := EnsureSecretSinks(client, tt.pod, targets)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ctx := context.TODO()
+			for _, secretName := range tt.targets {
+				secret, err := client.CoreV1().Secrets("apps").Get(ctx, secretName, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get secret %s: %v", secretName, err)
+				}
+				if got := ownerNames(secret.OwnerReferences); tt.wantSecretOwner != "" && !slices.Equal(got, []string{tt.wantSecretOwner}) {
+					t.Errorf("secret %s: got owners %v, want %s", secretName, got, tt.wantSecretOwner)
+				}
+			}
+
+			role, err := client.RbacV1().Roles("apps").Get(ctx, roleName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get role: %v", err)
+			}
+			if got := role.Rules[0].ResourceNames; !slices.Equal(got, tt.wantResourceNames) {
+				t.Errorf("got resource names %v, want %v", got, tt.wantResourceNames)
+			}
+			if got := ownerNames(role.OwnerReferences); !slices.Equal(got, tt.wantRoleOwners) {
+				t.Errorf("got role owners %v, want %v", got, tt.wantRoleOwners)
+			}
+
+			roleBinding, err := client.RbacV1().RoleBindings("apps").Get(ctx, roleName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get role binding: %v", err)
+			}
+			wantBindingOwners := tt.wantBindingOwners
+			if wantBindingOwners == nil {
+				wantBindingOwners = tt.wantRoleOwners
+			}
+			if got := ownerNames(roleBinding.OwnerReferences); !slices.Equal(got, wantBindingOwners) {
+				t.Errorf("got role binding owners %v, want %v", got, wantBindingOwners)
+			}
+			if subject := roleBinding.Subjects[0]; subject.Kind != rbacv1.ServiceAccountKind || subject.Name != "app" {
+				t.Errorf("got subject %+v, want service account app", subject)
+			}
+		})
+	}
+}
+
+func TestAppendOwnerReference(t *testing.T) {
+	// fake clients don't set uids, owners of different kinds or names are still distinct
+	owners := []metav1.OwnerReference{{APIVersion: "v1", Kind: "Secret", Name: "db", UID: types.UID("")}}
+
+	if _, updated := appendOwnerReference(owners, &metav1.OwnerReference{APIVersion: "v1", Kind: "Secret", Name: "db"}); updated {
+		t.Errorf("same owner was appended")
+	}
+	if _, updated := appendOwnerReference(owners, &metav1.OwnerReference{APIVersion: "v1", Kind: "Secret", Name: "api"}); !updated {
+		t.Errorf("other owner wasn't appended")
+	}
+	if _, updated := appendOwnerReference(owners, nil); updated {
+		t.Errorf("nil owner was appended")
+	}
+}
+
+func ownerNames(ownerReferences []metav1.OwnerReference) []string {
+	var names []string
+	for _, ownerReference := range ownerReferences {
+		names = append(names, ownerReference.Kind+"/"+ownerReference.Name)
+	}
+	return names
+}
+
+func boolPointer(value bool) *bool {
+	return &value
+}
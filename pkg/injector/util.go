@@ -3,9 +3,9 @@ package injector
 import (
 	"context"
 	"fmt"
+	"log/slog"
 
 	"github.com/Infisical/infisical-agent-injector/pkg/util"
-	"gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -15,12 +15,48 @@ func IsInjectable(pod corev1.Pod) bool {
 	return pod.Annotations[util.InjectAnnotation] == "true"
 }
 
-func GetConfigMap(client kubernetes.Interface, pod corev1.Pod) (*util.ConfigMap, error) {
+// GetConfigMap resolves the agent config of the pod. a config map or InfisicalAgentConfig referenced by annotation takes precedence,
+// then an InfisicalAgentConfig in the pod namespace whose pod-selector matches the pod, then a matching ClusterInfisicalAgentConfig
+func GetConfigMap(client kubernetes.Interface, agentConfigCache *AgentConfigCache, pod corev1.Pod, logger *slog.Logger) (*util.ConfigMap, error) {
 	configMapName := pod.Annotations[util.AnnotationAgentConfigMap]
-	if configMapName == "" {
-		return nil, fmt.Errorf("no config map found")
+	agentConfigName := pod.Annotations[util.AnnotationAgentConfigResource]
+
+	if configMapName != "" && agentConfigName != "" {
+		return nil, fmt.Errorf("only one of %s and %s can be set", util.AnnotationAgentConfigMap, util.AnnotationAgentConfigResource)
 	}
 
+	var agentConfig *util.ConfigMap
+	var resolvedBy string
+	var err error
+
+	switch {
+	case agentConfigName != "":
+		resolvedBy = util.AnnotationAgentConfigResource
+		agentConfig, err = GetAgentConfigResource(agentConfigCache, pod.Namespace, agentConfigName)
+	case configMapName != "":
+		resolvedBy = util.AnnotationAgentConfigMap
+		agentConfig, err = getAgentConfigMap(client, pod, configMapName, logger)
+	default:
+		resolvedBy = "pod selector"
+		agentConfig, err = FindNamespaceAgentConfig(agentConfigCache, pod)
+		if err == nil && agentConfig == nil {
+			resolvedBy = "namespace and pod selector"
+			agentConfig, err = FindClusterAgentConfig(agentConfigCache, pod)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if agentConfig == nil {
+		return nil, fmt.Errorf("no config map found, set %s or %s, or create an %s or %s that selects the pod", util.AnnotationAgentConfigMap, util.AnnotationAgentConfigResource, util.AgentConfigKind, util.ClusterAgentConfigKind)
+	}
+
+	logger.Info("Resolved agent config", "source", agentConfig.Source, "resolved_by", resolvedBy)
+	return agentConfig, nil
+}
+
+func getAgentConfigMap(client kubernetes.Interface, pod corev1.Pod, configMapName string, logger *slog.Logger) (*util.ConfigMap, error) {
 	namespace := pod.Namespace
 	configMap, err := client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), configMapName, metav1.GetOptions{})
 	if err != nil {
@@ -28,10 +64,33 @@ func GetConfigMap(client kubernetes.Interface, pod corev1.Pod) (*util.ConfigMap,
 	}
 
 	// parse the config map
-	var parsedConfigMap util.ConfigMap
-	if err := yaml.Unmarshal([]byte(configMap.Data["config.yaml"]), &parsedConfigMap); err != nil {
+	parsedConfigMap, err := util.ParseConfigMapData(configMap.Data[util.AgentConfigMapDataKey])
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse ConfigMap data: %w", err)
 	}
+	parsedConfigMap.Source = "configmap/" + configMapName
+
+	// the config map controller only watches labelled config maps
+	if pod.Annotations[util.AnnotationRestartOnConfigChange] == "true" && configMap.Labels[util.LabelAgentConfig] != "true" {
+		logger.Warn("Config map is not labelled as an agent config, the pod won't be restarted when it changes", "config_map", configMapName, "label", util.LabelAgentConfig)
+		parsedConfigMap.Warnings = append(parsedConfigMap.Warnings, fmt.Sprintf("config map %s is not labelled %s=true, the pod won't be restarted when it changes", configMapName, util.LabelAgentConfig))
+	}
+
+	return parsedConfigMap, nil
+}
+
+// ValidateImagePullSecrets checks that the pull secrets added to the pod exist, otherwise the pod would fail to pull the injected images
+func ValidateImagePullSecrets(client kubernetes.Interface, namespace string, secretNames []string) error {
+	for _, secretName := range secretNames {
+		secret, err := client.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get image pull secret %s in namespace %s: %w", secretName, namespace, err)
+		}
+
+		if secret.Type != corev1.SecretTypeDockerConfigJson && secret.Type != corev1.SecretTypeDockercfg {
+			return fmt.Errorf("image pull secret %s in namespace %s must be of type %s, got %s", secretName, namespace, corev1.SecretTypeDockerConfigJson, secret.Type)
+		}
+	}
 
-	return &parsedConfigMap, nil
+	return nil
 }
@@ -0,0 +1,70 @@
+package injector
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetAgentConfigMapRestartLabelWarning(t *testing.T) {
+	tests := []struct {
+		name        string
+		labelled    bool
+		restart     bool
+		wantWarning bool
+	}{
+		{name: "unlabelled with restart", labelled: false, restart: true, wantWarning: true},
+		{name: "labelled with restart", labelled: true, restart: true, wantWarning: false},
+		{name: "unlabelled without restart", labelled: false, restart: false, wantWarning: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "agent-config", Namespace: "apps"},
+				Data:       map[string]string{util.AgentConfigMapDataKey: "templates: []\n"},
+			}
+			if tt.labelled {
+				configMap.Labels = map[string]string{util.LabelAgentConfig: "true"}
+			}
+
+			pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "apps", Annotations: map[string]string{}}}
+			if tt.restart {
+				pod.Annotations[util.AnnotationRestartOnConfigChange] = "true"
+			}
+
+			agentConfig, err := getAgentConfigMap(kubefake.NewSimpleClientset(configMap), pod, "agent-config", slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if gotWarning := len(agentConfig.Warnings) > 0; gotWarning != tt.wantWarning {
+				t.Errorf("got warnings %q, want warning %v", agentConfig.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestGetAgentConfigMapUnknownFields(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent-config", Namespace: "apps"},
+		Data:       map[string]string{util.AgentConfigMapDataKey: "templates: []\nlegacy-option: true\n"},
+	}
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "apps"}}
+
+	// configs saved before unknown fields were validated must still be injected
+	agentConfig, err := getAgentConfigMap(kubefake.NewSimpleClientset(configMap), pod, "agent-config", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(agentConfig.Warnings) != 1 || !strings.Contains(agentConfig.Warnings[0], "field legacy-option not found") {
+		t.Errorf("got warnings %q, want the unknown field", agentConfig.Warnings)
+	}
+}
@@ -0,0 +1,89 @@
+package injector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/agent"
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func (h *Handler) HandleValidateConfig(w http.ResponseWriter, r *http.Request) {
+	h.serveAdmissionReview(w, r, h.ValidateConfig)
+}
+
+// ValidateConfig rejects agent config maps that would fail to inject, so config errors surface at apply time instead of when a pod is created
+func (h *Handler) ValidateConfig(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{
+		Allowed: true,
+		UID:     req.UID,
+	}
+
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return resp
+	}
+
+	var configMap corev1.ConfigMap
+	if err := json.Unmarshal(req.Object.Raw, &configMap); err != nil {
+		return admissionsApiError(req.UID, err).Resp
+	}
+
+	// the webhook only selects labelled config maps, this guards against a misconfigured selector
+	if configMap.Labels[util.LabelAgentConfig] != "true" {
+		return resp
+	}
+
+	logger := slog.With(
+		"uid", req.UID,
+		"namespace", req.Namespace,
+		"config_map", req.Name,
+	)
+
+	data := configMap.Data[util.AgentConfigMapDataKey]
+
+	// labelled config maps must be valid for their os. otherwise the config may be used by pods of either os, so it's rejected if it's invalid for both
+	// and the results of the os it's invalid for are returned as warnings
+	operatingSystems := []string{util.OSLinux, util.OSWindows}
+	switch configMapOS := configMap.Labels[util.LabelAgentConfigOS]; configMapOS {
+	case "":
+		for _, os := range operatingSystems {
+			if err := agent.ValidateConfigData(data, []string{os}); err != nil {
+				resp.Warnings = append(resp.Warnings, fmt.Sprintf("infisical agent config is invalid for %s pods: %s", os, err))
+			}
+		}
+
+		if len(resp.Warnings) < len(operatingSystems) {
+			if len(resp.Warnings) > 0 {
+				resp.Warnings = append(resp.Warnings, fmt.Sprintf("set the %s label to the os of the pods using this config to reject it if it's invalid for that os", util.LabelAgentConfigOS))
+			}
+			logger.Info("Validated agent config map", "warnings", len(resp.Warnings))
+			return resp
+		}
+		resp.Warnings = nil
+	case util.OSLinux, util.OSWindows:
+		operatingSystems = []string{configMapOS}
+	default:
+		return admissionsApiError(req.UID, fmt.Errorf("invalid %s label %s. please use %s or %s", util.LabelAgentConfigOS, configMapOS, util.OSLinux, util.OSWindows)).Resp
+	}
+
+	if err := agent.ValidateConfigData(data, operatingSystems); err != nil {
+		logger.Info("Rejected invalid agent config map", "error", err)
+
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Message: fmt.Sprintf("invalid infisical agent config: %s", err),
+		}
+		return resp
+	}
+
+	logger.Info("Validated agent config map")
+	return resp
+}
@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// EnvLogLevel sets the log level of the injector and its subcommands, the --log-level flag takes precedence
+const EnvLogLevel = "LOG_LEVEL"
+
+const DefaultLogLevel = "info"
+
+// Setup makes the default slog logger write JSON lines to stderr at the given level (debug, info, warn or error).
+// the standard library log package writes through the same handler
+func Setup(level string) error {
+	if level == "" {
+		level = DefaultLogLevel
+	}
+
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return fmt.Errorf("invalid log level %s. please use debug, info, warn or error", level)
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slogLevel})))
+	return nil
+}
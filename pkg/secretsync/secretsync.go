@@ -0,0 +1,162 @@
+package secretsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Infisical/infisical-agent-injector/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Run is the entrypoint of the sync-secret command, which runs in the secret sync containers of injected pods.
+// it copies the templates rendered by the agent into the kubernetes secrets created by the injector.
+func Run(args []string) error {
+	flags := flag.NewFlagSet("sync-secret", flag.ExitOnError)
+	once := flags.Bool("once", false, "sync the secrets once and exit")
+	interval := flags.Duration("interval", util.DefaultSecretSyncIntervalSecs*time.Second, "how often to check the rendered templates for changes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var targets []util.SecretSyncTarget
+	if err := json.Unmarshal([]byte(os.Getenv(util.EnvSecretSyncTargets)), &targets); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", util.EnvSecretSyncTargets, err)
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes client config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create kubernetes clientset: %w", err)
+	}
+
+	syncer := &secretSyncer{
+		client:    client,
+		namespace: os.Getenv("NAMESPACE"),
+		podName:   os.Getenv("POD_NAME"),
+		podUID:    types.UID(os.Getenv("POD_UID")),
+		targets:   targets,
+		synced:    map[string]map[string][]byte{},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if *once {
+		// the agent init container has already rendered every template, so missing files are an error
+		return syncer.sync(ctx, true)
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		if err := syncer.sync(ctx, false); err != nil {
+			slog.Error("Failed to sync secrets", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+type secretSyncer struct {
+	client    kubernetes.Interface
+	namespace string
+	podName   string
+	podUID    types.UID
+	targets   []util.SecretSyncTarget
+
+	// last data written per secret, so we only update the secret when a template is re-rendered
+	synced map[string]map[string][]byte
+}
+
+func (s *secretSyncer) sync(ctx context.Context, requireFiles bool) error {
+	secretsData := map[string]map[string][]byte{}
+
+	for _, target := range s.targets {
+		content, err := os.ReadFile(target.Path)
+		if errors.Is(err, os.ErrNotExist) && !requireFiles {
+			// the sidecar agent hasn't rendered this template yet
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read rendered template %s: %w", target.Path, err)
+		}
+
+		if secretsData[target.SecretName] == nil {
+			secretsData[target.SecretName] = map[string][]byte{}
+		}
+
+		if target.Format == util.KubernetesSecretFormatDotenv {
+			values, err := util.ParseDotenv(content)
+			if err != nil {
+				return fmt.Errorf("failed to parse rendered template %s: %w", target.Path, err)
+			}
+			maps.Copy(secretsData[target.SecretName], values)
+		} else {
+			secretsData[target.SecretName][target.Key] = content
+		}
+	}
+
+	for secretName, data := range secretsData {
+		if maps.EqualFunc(s.synced[secretName], data, bytes.Equal) {
+			continue
+		}
+
+		if err := s.updateSecret(ctx, secretName, data); err != nil {
+			return err
+		}
+
+		s.synced[secretName] = data
+		slog.Info("Synced keys to secret", "namespace", s.namespace, "secret", secretName, "keys", len(data))
+	}
+
+	return nil
+}
+
+func (s *secretSyncer) updateSecret(ctx context.Context, name string, data map[string][]byte) error {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s in namespace %s: %w", name, s.namespace, err)
+	}
+
+	secret.Data = data
+
+	// pods without a workload can't be set as owner by the injector, so we clean up the secret with the pod instead
+	if len(secret.OwnerReferences) == 0 && s.podName != "" && s.podUID != "" {
+		secret.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       s.podName,
+				UID:        s.podUID,
+			},
+		}
+	}
+
+	if _, err := s.client.CoreV1().Secrets(s.namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update secret %s in namespace %s: %w", name, s.namespace, err)
+	}
+
+	return nil
+}
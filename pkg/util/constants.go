@@ -4,22 +4,60 @@ import (
 	"encoding/json"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 const (
 	InjectAnnotation                      = "org.infisical.com/inject"
 	InjectModeAnnotation                  = "org.infisical.com/inject-mode"
 	AnnotationAgentConfigMap              = "org.infisical.com/agent-config-map"
+	AnnotationAgentConfigResource         = "org.infisical.com/agent-config-resource" // name of an InfisicalAgentConfig, used instead of the config map
+	AnnotationAgentConfigSource           = "org.infisical.com/agent-config-source"   // set by the injector, the kind and name of the config the pod was injected with
 	AnnotationAgentStatus                 = "org.infisical.com/agent-status"
 	AnnotationCachingEnabled              = "org.infisical.com/agent-cache-enabled"
 	AnnotationRevokeCredentialsOnShutdown = "org.infisical.com/agent-revoke-on-shutdown"
 	AnnotationAgentImage                  = "org.infisical.com/agent-image"
+	AnnotationAgentOS                     = "org.infisical.com/agent-os" // linux or windows, overrides os detection
+	AnnotationAgentImagePullPolicy        = "org.infisical.com/agent-image-pull-policy"
+	AnnotationAgentConfigHash             = "org.infisical.com/agent-config-hash"
+	AnnotationRestartOnConfigChange       = "org.infisical.com/agent-restart-on-config-change"
 
-	AnnotationSetSecurityContext                    = "org.infisical.com/agent-set-security-context"
+	AnnotationSetSecurityContext                    = "org.infisical.com/agent-set-security-context" // true, false or inherit
 	AnnotationSecurityContextRunAsUser              = "org.infisical.com/agent-security-context-run-as-user"
 	AnnotationSecurityContextRunAsGroup             = "org.infisical.com/agent-security-context-run-as-group"
 	AnnotationSecurityContextReadOnlyRootFilesystem = "org.infisical.com/agent-security-context-read-only-root-filesystem"
 
+	// comma separated list of app containers whose entrypoint is wrapped to load env templates, defaults to all app containers
+	AnnotationEnvContainers = "org.infisical.com/agent-env-containers"
+	// suffixed with the container name. JSON array with the image entrypoint, required when the container doesn't set a command
+	AnnotationEnvCommandPrefix = "org.infisical.com/agent-env-command-"
+
+	// templates declared on the pod, merged with the templates of the config. suffixed with the template name, e.g. agent-inject-template-db
+	AnnotationInjectTemplatePrefix    = "org.infisical.com/agent-inject-template-"
+	AnnotationInjectDestinationPrefix = "org.infisical.com/agent-inject-destination-"
+
+	// kubectl annotation naming the main container of the pod, used as the primary container when inheriting the security context
+	AnnotationDefaultContainer = "kubectl.kubernetes.io/default-container"
+
+	AnnotationHTTPProxy    = "org.infisical.com/agent-http-proxy"
+	AnnotationHTTPSProxy   = "org.infisical.com/agent-https-proxy"
+	AnnotationNoProxy      = "org.infisical.com/agent-no-proxy"
+	AnnotationProxyInherit = "org.infisical.com/agent-proxy-inherit" // use the proxy env vars of the primary app container
+
+	// harden (default) or fail. what to do when the injected containers violate the pod security level enforced on the namespace
+	AnnotationPodSecurity = "org.infisical.com/agent-pod-security"
+
+	AnnotationInitTimeout = "org.infisical.com/agent-init-timeout"
+	// blocks the app containers until the sidecar has rendered the secrets, uses the init timeout
+	AnnotationWaitForSecrets = "org.infisical.com/agent-wait-for-secrets"
+
+	AnnotationProbesEnabled                 = "org.infisical.com/agent-probes-enabled"
+	AnnotationStartupProbePeriodSeconds     = "org.infisical.com/agent-startup-probe-period-seconds"
+	AnnotationStartupProbeFailureThreshold  = "org.infisical.com/agent-startup-probe-failure-threshold"
+	AnnotationLivenessProbePeriodSeconds    = "org.infisical.com/agent-liveness-probe-period-seconds"
+	AnnotationLivenessProbeFailureThreshold = "org.infisical.com/agent-liveness-probe-failure-threshold"
+	AnnotationLivenessTokenMaxAge           = "org.infisical.com/agent-liveness-token-max-age" // e.g. "1h", the token file must have been renewed within this time
+
 	AnnotationAgentClientMaxRetries = "org.infisical.com/agent-client-max-retries"
 	AnnotationAgentClientBaseDelay  = "org.infisical.com/agent-client-base-delay"
 	AnnotationAgentClientMaxDelay   = "org.infisical.com/agent-client-max-delay"
@@ -31,24 +69,222 @@ const (
 	AnnotationRequestsCPU       = "org.infisical.com/agent-requests-cpu"
 	AnnotationRequestsMemory    = "org.infisical.com/agent-requests-memory"
 	AnnotationRequestsEphemeral = "org.infisical.com/agent-requests-ephemeral"
+
+	// the per-field resource annotations above take precedence over the values of the profile
+	AnnotationResourceProfile = "org.infisical.com/agent-resource-profile"
+
+	// the resource annotations can be set for the init or sidecar containers only by adding "init-" or "sidecar-" after this prefix,
+	// e.g. org.infisical.com/agent-init-limits-cpu. these take precedence over the annotations without a role
+	AnnotationAgentPrefix = "org.infisical.com/agent-"
+)
+
+// the proxy configured on the injector for all agent containers
+const (
+	EnvAgentHTTPProxy  = "AGENT_HTTP_PROXY"
+	EnvAgentHTTPSProxy = "AGENT_HTTPS_PROXY"
+	EnvAgentNoProxy    = "AGENT_NO_PROXY"
+)
+
+const (
+	ContainerRoleInit    = "init"
+	ContainerRoleSidecar = "sidecar"
 )
 
+const (
+	ResourceProfileSmall   = "small"
+	ResourceProfileDefault = "default"
+	ResourceProfileLarge   = "large"
+
+	EnvAgentResourceProfiles = "AGENT_RESOURCE_PROFILES" // JSON object of profile name to ResourceProfile, merged with DefaultResourceProfiles
+)
+
+// we don't set ephemeral storage as it first became generally available in k8s 1.25.
+// additionally we want to let the cluster dictacte the pods ephemeral storage limits if not explicitly provided by the user.
+// windows pods need much more memory than linux pods (this resolves out of memory restarts)
+var DefaultResourceProfiles = map[string]ResourceProfile{
+	ResourceProfileSmall: {
+		Requests: ResourceValues{CPU: "50m", Memory: "32Mi"},
+		Limits:   &ResourceValues{CPU: "250m", Memory: "64Mi"},
+		Windows: &ResourceProfile{
+			Requests: ResourceValues{CPU: "50m", Memory: "128Mi"},
+			Limits:   &ResourceValues{CPU: "250m", Memory: "256Mi"},
+		},
+	},
+	ResourceProfileDefault: {
+		Requests: ResourceValues{CPU: "100m", Memory: "64Mi"},
+		Limits:   &ResourceValues{CPU: "500m", Memory: "128Mi"},
+		Windows: &ResourceProfile{
+			Requests: ResourceValues{CPU: "100m", Memory: "256Mi"},  // 2x more
+			Limits:   &ResourceValues{CPU: "500m", Memory: "512Mi"}, // 4x more
+		},
+	},
+	ResourceProfileLarge: {
+		Requests: ResourceValues{CPU: "250m", Memory: "128Mi"},
+		Limits:   &ResourceValues{CPU: "1", Memory: "256Mi"},
+		Windows: &ResourceProfile{
+			Requests: ResourceValues{CPU: "250m", Memory: "512Mi"},
+			Limits:   &ResourceValues{CPU: "1", Memory: "1Gi"},
+		},
+	},
+}
+
 var KubeSystemNamespaces = []string{
 	metav1.NamespaceSystem,
 	metav1.NamespacePublic,
 }
 
+// the InfisicalAgentConfig custom resource, a typed alternative to the agent config map
+const (
+	AgentConfigGroup    = "agent.infisical.com"
+	AgentConfigVersion  = "v1alpha1"
+	AgentConfigResource = "infisicalagentconfigs"
+	AgentConfigKind     = "InfisicalAgentConfig"
+
+	ClusterAgentConfigResource = "clusterinfisicalagentconfigs"
+	ClusterAgentConfigKind     = "ClusterInfisicalAgentConfig"
+)
+
+var AgentConfigGVR = schema.GroupVersionResource{
+	Group:    AgentConfigGroup,
+	Version:  AgentConfigVersion,
+	Resource: AgentConfigResource,
+}
+
+var ClusterAgentConfigGVR = schema.GroupVersionResource{
+	Group:    AgentConfigGroup,
+	Version:  AgentConfigVersion,
+	Resource: ClusterAgentConfigResource,
+}
+
+const (
+	AgentConfigMapDataKey = "config.yaml"
+	// config maps with this label set to "true" are validated by the validating webhook when they are created or updated,
+	// and watched by the config map controller to restart workloads on config changes
+	LabelAgentConfig = "org.infisical.com/agent-config"
+	// restricts the validation of a labelled config map to one os (linux or windows), for configs that are only used by pods of that os
+	LabelAgentConfigOS = "org.infisical.com/agent-os"
+	// set to "true" on injected pods, so the controllers can list them without listing every pod in the cluster
+	LabelAgentInjected = "org.infisical.com/agent-injected"
+
+	// same annotation as `kubectl rollout restart`
+	AnnotationRestartedAt = "kubectl.kubernetes.io/restartedAt"
+)
+
 const (
 	DefaultDestinationPath                 = "/shared/infisical-secrets"
 	AccessTokenSinkFileDestinationFileName = "/identity-access-token"
 )
 
+const (
+	OSLinux   = "linux"
+	OSWindows = "windows"
+
+	LabelOS          = "kubernetes.io/os"
+	LabelArch        = "kubernetes.io/arch"
+	LabelBetaOS      = "beta.kubernetes.io/os"
+	LabelBetaArch    = "beta.kubernetes.io/arch"
+	DefaultPodArch   = "amd64"
+	EnvAgentImageMap = "AGENT_IMAGE_MAP" // JSON object of "os/arch" to agent image, merged with DefaultAgentImageMap
+)
+
+const (
+	EnvAgentImagePullSecrets = "AGENT_IMAGE_PULL_SECRETS" // JSON object of registry to the names of the pull secrets for images from that registry
+	EnvAgentImagePullPolicy  = "AGENT_IMAGE_PULL_POLICY"
+	DefaultImageRegistry     = "docker.io"
+)
+
+// the linux image is multi-arch
+var DefaultAgentImageMap = map[string]string{
+	"linux/amd64":   DefaultLinuxContainerImage,
+	"linux/arm64":   DefaultLinuxContainerImage,
+	"windows/amd64": DefaultWindowsContainerImage,
+}
+
+const (
+	DefaultInitTimeoutSeconds = 180
+
+	DefaultStartupProbePeriodSeconds     = 2
+	DefaultStartupProbeFailureThreshold  = 90 // 3 minutes, same as the default init timeout
+	DefaultLivenessProbePeriodSeconds    = 30
+	DefaultLivenessProbeFailureThreshold = 3
+
+	// exec probes time out after a second by default, starting pwsh.exe alone can take longer on windows nodes
+	ProbeTimeoutSeconds        = 5
+	WindowsProbeTimeoutSeconds = 15
+)
+
 const (
 	InjectModeInit        = "init"
 	InjectModeSidecar     = "sidecar"
 	InjectModeSidecarInit = "sidecar-init"
 )
 
+const (
+	LabelPodSecurityEnforce = "pod-security.kubernetes.io/enforce"
+
+	PodSecurityLevelPrivileged = "privileged"
+	PodSecurityLevelBaseline   = "baseline"
+	PodSecurityLevelRestricted = "restricted"
+
+	PodSecurityModeHarden = "harden"
+	PodSecurityModeFail   = "fail"
+)
+
+const (
+	// derives the agent security context from the pod and its primary container
+	SecurityContextModeInherit = "inherit"
+
+	DefaultSecurityContextRunAsUser  = 1000
+	DefaultSecurityContextRunAsGroup = 2000
+)
+
+const (
+	DefaultOnChangeTimeoutSeconds = 30
+	DefaultOnChangeHTTPMethod     = "POST"
+)
+
+var SupportedOnChangeSignals = []string{"SIGHUP", "SIGINT", "SIGQUIT", "SIGTERM", "SIGUSR1", "SIGUSR2"}
+
+// the agent runs its startup script with these, so they'd be signalled in the shared process namespace as well
+var AgentShellProcesses = []string{"sh", "ash", "bash", "dash", "busybox"}
+
+// pkill -x matches the process name, which the kernel truncates to 15 characters
+const ProcessNameMaxLength = 15
+
+// formats of templates that are generated by the injector
+const (
+	TemplateFormatDotenv = "dotenv"
+	TemplateFormatJSON   = "json"
+	TemplateFormatYAML   = "yaml"
+
+	DefaultTemplateSecretsPath = "/"
+)
+
+var SupportedTemplateFormats = []string{TemplateFormatDotenv, TemplateFormatJSON, TemplateFormatYAML}
+
+const (
+	KubernetesSecretFormatFile   = "file"
+	KubernetesSecretFormatDotenv = "dotenv"
+
+	// set on secrets and rbac resources created by the injector for kubernetes secret sinks
+	LabelManagedBy      = "app.kubernetes.io/managed-by"
+	LabelManagedByValue = "infisical-agent-injector"
+
+	// env var set on the injector deployment with its own image, used to run the secret sync containers
+	EnvInjectorImage = "INJECTOR_IMAGE"
+
+	EnvSecretSyncTargets          = "INFISICAL_SECRET_SYNC_TARGETS"
+	DefaultSecretSyncIntervalSecs = 10
+)
+
+const (
+	EnvWrapperVolumeName      = "infisical-env-wrapper"
+	EnvWrapperMountPath       = "/home/.infisical-bin"
+	EnvWrapperBinaryName      = "infisical-env-exec"
+	InjectorBinaryPath        = "/app/infisical-agent-injector"
+	DefaultEnvExecTimeoutSecs = 180
+)
+
 const (
 	KubernetesAuthType = "kubernetes"
 	LdapAuthType       = "ldap-auth"
@@ -58,11 +294,26 @@ const (
 const (
 	InitContainerName            = "infisical-agent-init"
 	SidecarContainerName         = "infisical-agent"
+	SecretSyncInitContainerName  = "infisical-secret-sync-init"
+	SecretSyncContainerName      = "infisical-secret-sync"
+	EnvWrapperInitContainerName  = "infisical-env-wrapper-init"
 	DefaultLinuxContainerImage   = "infisical/cli:0.43.55"
-	DefaultWindowsContainerImage = "infisical/cli:0.43.55-windows-amd64" // note(daniel): currently only windows amd64 is supported. we throw if the user is trying to use a different architecture on windows.
+	DefaultWindowsContainerImage = "infisical/cli:0.43.55-windows-amd64" // note(daniel): currently only windows amd64 is supported. we throw if the user is trying to use a different architecture on windows, unless an image is configured for it in the agent image map.
 
 	ContainerAgentConfigVolumeName = "infisical-agent-config"
 
+	ContainerTLSVolumeName       = "infisical-tls"
+	LinuxContainerTLSMountPath   = "/home/.infisical-tls"
+	WindowsContainerTLSMountPath = "C:\\.infisical-tls"
+	TLSCABundleDir               = "ca"
+	DefaultTLSCABundleKey        = "ca.crt"
+	LinuxSystemCertificatesDir   = "/etc/ssl/certs"
+
+	// the output of the init agent is only mounted into the init agent container, the work dir is shared with the app containers
+	ContainerAgentLogVolumeName       = "infisical-agent-log"
+	LinuxContainerAgentLogMountPath   = "/home/.infisical-agent-log"
+	WindowsContainerAgentLogMountPath = "C:\\.infisical-agent-log"
+
 	ContainerWorkDirMountName              = "infisical-work-dir"
 	LinuxContainerWorkDirVolumeMountPath   = "/home/.infisical-workdir"
 	WindowsContainerWorkDirVolumeMountPath = "C:\\.infisical-workdir"
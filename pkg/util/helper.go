@@ -1,16 +1,28 @@
 package util
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/Infisical/infisical-agent-injector/pkg/templates"
 	"gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func PrettyPrintJSON(data []byte) string {
@@ -30,6 +42,12 @@ func PrettyPrintJSON(data []byte) string {
 	return string(prettyJSON)
 }
 
+// HashConfigData returns a short hash of the raw agent config, stamped on injected pods
+func HashConfigData(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])[:16]
+}
+
 func IsWindowsPod(pod *corev1.Pod) bool {
 	// check the OS field (supported in k8s 1.25+)
 	if pod.Spec.OS != nil && pod.Spec.OS.Name == "windows" {
@@ -82,16 +100,223 @@ func IsWindowsPod(pod *corev1.Pod) bool {
 	return false
 }
 
+// PrefersWindowsNodes is a weaker signal than IsWindowsPod, for pods that aren't required to run on windows but are steered there by preferred node affinity.
+// tolerations aren't considered, as tolerating a windows taint allows a pod onto windows nodes without requiring it
+func PrefersWindowsNodes(pod *corev1.Pod) bool {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		return false
+	}
+
+	var windowsWeight, otherWeight int32
+
+	for _, preferredTerm := range pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		for _, expression := range preferredTerm.Preference.MatchExpressions {
+			if (expression.Key != LabelOS && expression.Key != LabelBetaOS) || expression.Operator != corev1.NodeSelectorOpIn {
+				continue
+			}
+
+			if slices.Contains(expression.Values, OSWindows) {
+				windowsWeight += preferredTerm.Weight
+			} else {
+				otherWeight += preferredTerm.Weight
+			}
+		}
+	}
+
+	return windowsWeight > otherWeight
+}
+
+// GetPodArchitectures returns the cpu architectures the pod is constrained to by its node selector or required node affinity
+func GetPodArchitectures(pod *corev1.Pod) []string {
+	for _, label := range []string{LabelArch, LabelBetaArch} {
+		if arch, exists := pod.Spec.NodeSelector[label]; exists && arch != "" {
+			return []string{arch}
+		}
+	}
+
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nil
+	}
+
+	// node selector terms are ORed, so the pod can run on any architecture allowed by any of the terms.
+	// if a single term doesn't constrain the architecture, the pod can run anywhere.
+	var architectures []string
+	for _, nodeSelectorTerm := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		termConstrained := false
+
+		for _, expression := range nodeSelectorTerm.MatchExpressions {
+			if (expression.Key == LabelArch || expression.Key == LabelBetaArch) && expression.Operator == corev1.NodeSelectorOpIn {
+				termConstrained = true
+				for _, value := range expression.Values {
+					if !slices.Contains(architectures, value) {
+						architectures = append(architectures, value)
+					}
+				}
+			}
+		}
+
+		if !termConstrained {
+			return nil
+		}
+	}
+
+	return architectures
+}
+
+// GetAgentImageMap returns the agent image per "os/arch", with the images configured on the injector taking precedence over the defaults
+func GetAgentImageMap() map[string]string {
+	imageMap := maps.Clone(DefaultAgentImageMap)
+	maps.Copy(imageMap, configuredAgentImageMap)
+
+	return imageMap
+}
+
+// ImageRegistry returns the registry host of an image reference, images without a registry are pulled from docker hub
+func ImageRegistry(image string) string {
+	firstSegment, _, hasSlash := strings.Cut(image, "/")
+	if !hasSlash {
+		return DefaultImageRegistry
+	}
+
+	if strings.ContainsAny(firstSegment, ".:") || firstSegment == "localhost" {
+		return firstSegment
+	}
+
+	return DefaultImageRegistry
+}
+
+// GetImagePullSecrets returns the pull secrets configured on the injector for the registry of the image
+func GetImagePullSecrets(image string) []string {
+	return configuredImagePullSecrets[ImageRegistry(image)]
+}
+
+// GetInjectorImage returns the image of the injector, which runs the secret sync and env wrapper containers. empty if not configured
+func GetInjectorImage() string {
+	return configuredInjectorImage
+}
+
+// GetAgentImagePullPolicy returns the pull policy configured on the injector for the agent containers, empty if none is set
+func GetAgentImagePullPolicy() corev1.PullPolicy {
+	return configuredImagePullPolicy
+}
+
+// GetAgentProxy returns the proxy configured on the injector for the agent containers
+func GetAgentProxy() ProxyConfig {
+	return configuredAgentProxy
+}
+
+// ParseConfigMapData parses the config.yaml of an agent config map. unknown fields are ignored so existing configs keep working,
+// and are added to the warnings of the config so typos still surface
+func ParseConfigMapData(data string) (*ConfigMap, error) {
+	configMap, err := decodeConfigMapData(data, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// the lenient decode succeeded, so the strict one can only fail on unknown fields
+	if _, err := decodeConfigMapData(data, true); err != nil {
+		var typeError *yaml.TypeError
+		if errors.As(err, &typeError) {
+			for _, message := range typeError.Errors {
+				configMap.Warnings = append(configMap.Warnings, fmt.Sprintf("ignoring unknown field in %s: %s", AgentConfigMapDataKey, message))
+			}
+		} else {
+			configMap.Warnings = append(configMap.Warnings, fmt.Sprintf("ignoring unknown fields in %s: %s", AgentConfigMapDataKey, err))
+		}
+	}
+
+	return configMap, nil
+}
+
+// ParseConfigMapDataStrict parses the config.yaml of an agent config map and rejects unknown fields. used to validate configs when they are saved
+func ParseConfigMapDataStrict(data string) (*ConfigMap, error) {
+	return decodeConfigMapData(data, true)
+}
+
+func decodeConfigMapData(data string, strict bool) (*ConfigMap, error) {
+	var configMap ConfigMap
+
+	decoder := yaml.NewDecoder(strings.NewReader(data))
+	decoder.KnownFields(strict)
+	if err := decoder.Decode(&configMap); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	configMap.Hash = HashConfigData(data)
+
+	return &configMap, nil
+}
+
+// GetResourceProfile returns the named profile. profiles of the config map take precedence over the profiles configured on the injector, which take precedence over the defaults
+func GetResourceProfile(configMap *ConfigMap, name string) (ResourceProfile, error) {
+	if profile, exists := configMap.ResourceProfiles[name]; exists {
+		return profile, nil
+	}
+
+	if profile, exists := configuredResourceProfiles[name]; exists {
+		return profile, nil
+	}
+
+	if profile, exists := DefaultResourceProfiles[name]; exists {
+		return profile, nil
+	}
+
+	return ResourceProfile{}, fmt.Errorf("resource profile %s not found", name)
+}
+
+// ResourceList parses the values, empty values are left out
+func (v ResourceValues) ResourceList() (corev1.ResourceList, error) {
+	resources := corev1.ResourceList{}
+
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:              v.CPU,
+		corev1.ResourceMemory:           v.Memory,
+		corev1.ResourceEphemeralStorage: v.EphemeralStorage,
+	} {
+		if value == "" {
+			continue
+		}
+
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s quantity %s: %w", name, value, err)
+		}
+		resources[name] = quantity
+	}
+
+	return resources, nil
+}
+
+// GetAgentImage returns the agent image for the pod platform. if the pod can run on multiple architectures, they must all use the same (multi-arch) image.
+func GetAgentImage(platform PodPlatform) (string, error) {
+	imageMap := GetAgentImageMap()
+
+	architectures := platform.Architectures
+	if len(architectures) == 0 {
+		architectures = []string{DefaultPodArch}
+	}
+
+	var image string
+	for _, arch := range architectures {
+		platformImage, exists := imageMap[platform.OS+"/"+arch]
+		if !exists || platformImage == "" {
+			return "", fmt.Errorf("agent does not support %s/%s. set the %s annotation or configure an image for it in the injector agent image map", platform.OS, arch, AnnotationAgentImage)
+		}
+
+		if image != "" && image != platformImage {
+			return "", fmt.Errorf("pod can be scheduled on architectures %s which use different agent images. please pin the pod to one architecture with the %s node selector", strings.Join(architectures, ", "), LabelArch)
+		}
+		image = platformImage
+	}
+
+	return image, nil
+}
+
 func BuildAgentConfigFromConfigMap(configMap *ConfigMap, exitAfterAuth bool, isWindowsPod bool, injectMode string, cachingEnabled bool, podAnnotations map[string]string) (*AgentConfig, []corev1.EnvVar, error) {
 
 	if configMap == nil {
 		return nil, nil, fmt.Errorf("config map is required")
 	}
 
-	if injectMode == InjectModeInit && configMap.Infisical.RevokeCredentialsOnShutdown {
-		return nil, nil, fmt.Errorf("revoke credentials on shutdown is not supported when inject mode is 'init'")
-	}
-
 	var envVars []corev1.EnvVar = []corev1.EnvVar{}
 
 	delimiter := "/"
@@ -197,6 +422,67 @@ func BuildAgentConfigFromConfigMap(configMap *ConfigMap, exitAfterAuth bool, isW
 		}
 	}
 
+	// on-change actions are converted to commands the agent executes after re-rendering.
+	// the init agent only renders once before the app starts, so there is nothing to notify.
+	agentTemplates := make([]Template, len(configMap.Templates))
+	for i, template := range configMap.Templates {
+		agentTemplates[i] = template
+		agentTemplates[i].OnChange = nil
+		agentTemplates[i].KubernetesSecret = nil
+		agentTemplates[i].Env = false
+
+		if template.Format != "" {
+			templateContent, err := GenerateTemplateContent(template.Format, template.Secrets)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to generate template %s: %w", template.DestinationPath, err)
+			}
+			agentTemplates[i].Base64TemplateContent = base64.StdEncoding.EncodeToString([]byte(templateContent))
+			agentTemplates[i].Format = ""
+			agentTemplates[i].Secrets = nil
+		}
+
+		// the agent doesn't apply file permissions or ownership, the startup script applies them after the first render and the execute command after each re-render
+		agentTemplates[i].FilePermissions = ""
+		agentTemplates[i].Owner = nil
+		agentTemplates[i].Group = nil
+
+		if exitAfterAuth {
+			continue
+		}
+
+		fileModeCommand, err := BuildFileModeCommand(template)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build file mode command for template %s: %w", template.DestinationPath, err)
+		}
+
+		var commands []string
+		if fileModeCommand != "" {
+			commands = append(commands, fileModeCommand)
+		}
+
+		timeout := int64(DefaultOnChangeTimeoutSeconds)
+		if template.OnChange != nil {
+			command, err := BuildOnChangeCommand(template.OnChange, isWindowsPod)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to build on-change command for template %s: %w", template.DestinationPath, err)
+			}
+			commands = append(commands, command)
+
+			if template.OnChange.Timeout != 0 {
+				timeout = template.OnChange.Timeout
+			}
+		}
+
+		if len(commands) == 0 {
+			continue
+		}
+
+		agentTemplates[i].Config.Execute = &TemplateExecuteConfig{
+			Command: strings.Join(commands, " && "),
+			Timeout: timeout,
+		}
+	}
+
 	agentConfig := &AgentConfig{
 		Auth: AuthConfig{
 			Type: configMap.Infisical.Auth.Type,
@@ -204,10 +490,10 @@ func BuildAgentConfigFromConfigMap(configMap *ConfigMap, exitAfterAuth bool, isW
 		Infisical: InfisicalConfig{
 			Address:                     configMap.Infisical.Address,
 			ExitAfterAuth:               exitAfterAuth,
-			RevokeCredentialsOnShutdown: revokeCredentialsOnShutdown && !exitAfterAuth, // if set in configmap or annotation. only enable if sidecar container,
+			RevokeCredentialsOnShutdown: revokeCredentialsOnShutdown && !exitAfterAuth, // if set in configmap or annotation. only enable if sidecar container, ignored with a warning in init mode
 			RetryConfig:                 retryCfg,
 		},
-		Templates: configMap.Templates,
+		Templates: agentTemplates,
 		// we manage the sink files for the user so they won't need to configure this.
 		// also makes it easier in terms of volume management.
 		Sinks: []Sink{
@@ -268,26 +554,72 @@ func BuildAgentScript(configMap ConfigMap, exitAfterAuth bool, isWindowsPod bool
 		Value: base64AgentConfigYaml,
 	})
 
+	initTimeoutSeconds, err := GetInitTimeoutSeconds(&configMap, podAnnotations)
+	if err != nil {
+		return "", nil, err
+	}
+
+	scriptData := StartupScriptTemplateData{
+		ExitAfterAuth:          exitAfterAuth,
+		TimeoutSeconds:         initTimeoutSeconds,
+		LogDir:                 LinuxContainerAgentLogMountPath,
+		TerminationMessagePath: corev1.TerminationMessagePathDefault,
+	}
+
+	// file permissions and ownership are rejected on windows pods
+	if !isWindowsPod {
+		for _, template := range configMap.Templates {
+			fileModeCommand, err := BuildFileModeCommand(template)
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to build file mode command for template %s: %w", template.DestinationPath, err)
+			}
+			if fileModeCommand != "" {
+				scriptData.FileModes = append(scriptData.FileModes, FileModeCommand{
+					Path:    ShellQuote(template.DestinationPath),
+					Command: fileModeCommand,
+				})
+			}
+		}
+	}
+
+	if tls := configMap.Infisical.TLS; tls != nil {
+		delimiter := "/"
+		tlsMountPath := LinuxContainerTLSMountPath
+		if isWindowsPod {
+			delimiter = "\\"
+			tlsMountPath = WindowsContainerTLSMountPath
+		}
+
+		if tls.CABundle != nil {
+			scriptData.CABundleDir = tlsMountPath + delimiter + TLSCABundleDir
+
+			// windows ignores SSL_CERT_DIR, the startup script imports the bundle into the certificate store instead.
+			// on linux we keep the system certificates, so the agent can still reach public endpoints (e.g. aws sts)
+			if !isWindowsPod {
+				envVars = append(envVars, corev1.EnvVar{
+					Name:  "SSL_CERT_DIR",
+					Value: LinuxSystemCertificatesDir + ":" + scriptData.CABundleDir,
+				})
+			}
+		}
+	}
+
 	if isWindowsPod {
-		windowsScript, err := buildWindowsAgentScript(exitAfterAuth)
+		scriptData.LogDir = WindowsContainerAgentLogMountPath
+		windowsScript, err := buildWindowsAgentScript(scriptData)
 		if err != nil {
 			return "", nil, fmt.Errorf("failed to build windows agent script: %w", err)
 		}
 		return windowsScript, envVars, nil
 	}
-	linuxScript, err := buildLinuxAgentScript(exitAfterAuth)
+	linuxScript, err := buildLinuxAgentScript(scriptData)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to build linux agent script: %w", err)
 	}
 	return linuxScript, envVars, nil
 }
 
-func buildLinuxAgentScript(exitAfterAuth bool) (string, error) {
-	data := StartupScriptTemplateData{
-		ExitAfterAuth:  exitAfterAuth,
-		TimeoutSeconds: 180,
-	}
-
+func buildLinuxAgentScript(data StartupScriptTemplateData) (string, error) {
 	tmpl, err := template.ParseFS(templates.TemplatesFS, "linux-container-startup.sh.tmpl")
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template: %w", err)
@@ -301,12 +633,7 @@ func buildLinuxAgentScript(exitAfterAuth bool) (string, error) {
 	return buf.String(), nil
 }
 
-func buildWindowsAgentScript(exitAfterAuth bool) (string, error) {
-	data := StartupScriptTemplateData{
-		ExitAfterAuth:  exitAfterAuth,
-		TimeoutSeconds: 180,
-	}
-
+func buildWindowsAgentScript(data StartupScriptTemplateData) (string, error) {
 	tmpl, err := template.ParseFS(templates.TemplatesFS, "windows-container-startup.ps1.tmpl")
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template: %w", err)
@@ -320,6 +647,127 @@ func buildWindowsAgentScript(exitAfterAuth bool) (string, error) {
 	return buf.String(), nil
 }
 
+// BuildFileModeCommand returns the shell command that applies the file permissions, owner and group of a template to its rendered file, or "" if none are set
+func BuildFileModeCommand(template Template) (string, error) {
+	destinationPath := ShellQuote(template.DestinationPath)
+
+	var commands []string
+	if template.Owner != nil || template.Group != nil {
+		owner := ""
+		if template.Owner != nil {
+			owner = strconv.FormatInt(*template.Owner, 10)
+		}
+		if template.Group != nil {
+			owner += ":" + strconv.FormatInt(*template.Group, 10)
+		}
+		commands = append(commands, fmt.Sprintf("chown %s %s", owner, destinationPath))
+	}
+
+	if template.FilePermissions != "" {
+		mode, err := ParseFilePermissions(template.FilePermissions)
+		if err != nil {
+			return "", err
+		}
+		commands = append(commands, fmt.Sprintf("chmod %04o %s", mode, destinationPath))
+	}
+
+	return strings.Join(commands, " && "), nil
+}
+
+// BuildOnChangeCommand converts an on-change action into a shell command (pwsh on windows) the agent can execute
+func BuildOnChangeCommand(onChange *TemplateOnChange, isWindowsPod bool) (string, error) {
+	if onChange.Command != "" {
+		return onChange.Command, nil
+	}
+
+	if onChange.Signal != nil {
+		if isWindowsPod {
+			return "", fmt.Errorf("signal on-change actions are not supported on windows pods")
+		}
+		if onChange.Signal.Process == "" {
+			return "", fmt.Errorf("no process to signal")
+		}
+
+		// the pod shares its process namespace, so every process with this name is signalled, whichever container it runs in
+		signal := strings.TrimPrefix(strings.ToUpper(onChange.Signal.Signal), "SIG")
+		process := onChange.Signal.Process
+
+		// pkill -x matches the process name, which the kernel truncates. longer names are matched against the executable in the command line instead
+		if len(process) > ProcessNameMaxLength {
+			pattern := "^([^ ]*/)?" + regexp.QuoteMeta(process) + "( |$)"
+			return fmt.Sprintf("pkill -%s -f %s", signal, ShellQuote(pattern)), nil
+		}
+
+		return fmt.Sprintf("pkill -%s -x %s", signal, ShellQuote(process)), nil
+	}
+
+	if onChange.HTTP != nil {
+		method := strings.ToUpper(onChange.HTTP.Method)
+		if method == "" {
+			method = DefaultOnChangeHTTPMethod
+		}
+
+		if isWindowsPod {
+			return fmt.Sprintf("pwsh.exe -Command \"Invoke-WebRequest -UseBasicParsing -Method %s -Uri %s | Out-Null\"", method, PowerShellQuote(onChange.HTTP.URL)), nil
+		}
+
+		if method == "GET" {
+			return fmt.Sprintf("wget -q -O /dev/null %s", ShellQuote(onChange.HTTP.URL)), nil
+		}
+		// busybox wget (used by the agent image) only supports GET and POST
+		return fmt.Sprintf("wget -q -O /dev/null --post-data='' %s", ShellQuote(onChange.HTTP.URL)), nil
+	}
+
+	return "", fmt.Errorf("on-change requires one of command, signal or http")
+}
+
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func PowerShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// GetInitTimeoutSeconds returns how long the init agent may run before it's killed. the annotation takes precedence over the config map.
+func GetInitTimeoutSeconds(configMap *ConfigMap, podAnnotations map[string]string) (int, error) {
+	if podAnnotations[AnnotationInitTimeout] != "" {
+		timeoutSeconds, err := ParseDurationSeconds(podAnnotations[AnnotationInitTimeout])
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse init timeout annotation: %w", err)
+		}
+		return timeoutSeconds, nil
+	}
+
+	if configMap.Infisical.InitTimeout != "" {
+		timeoutSeconds, err := ParseDurationSeconds(configMap.Infisical.InitTimeout)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse init-timeout: %w", err)
+		}
+		return timeoutSeconds, nil
+	}
+
+	return DefaultInitTimeoutSeconds, nil
+}
+
+// ParseDurationSeconds parses a duration such as "5m" or a plain number of seconds, rounded up to whole seconds
+func ParseDurationSeconds(stringValue string) (int, error) {
+	seconds, err := strconv.Atoi(stringValue)
+	if err != nil {
+		duration, durationErr := time.ParseDuration(stringValue)
+		if durationErr != nil {
+			return 0, fmt.Errorf("invalid duration, must be a number of seconds or a duration (e.g. 5m): %s", stringValue)
+		}
+		seconds = int(math.Ceil(duration.Seconds()))
+	}
+
+	if seconds <= 0 {
+		return 0, fmt.Errorf("invalid duration, must be greater than zero: %s", stringValue)
+	}
+
+	return seconds, nil
+}
+
 func ValidateInjectMode(injectMode string) error {
 	if injectMode != InjectModeSidecarInit && injectMode != InjectModeInit && injectMode != InjectModeSidecar {
 		return fmt.Errorf("inject mode %s not supported. please use %s, %s, or %s", injectMode, InjectModeInit, InjectModeSidecar, InjectModeSidecarInit)
@@ -356,3 +804,201 @@ func ParseStringToInt(stringValue string, defaultValue int) (int, error) {
 
 	return intValue, nil
 }
+
+// ParseFilePermissions parses an octal file mode such as "0640" or "640"
+func ParseFilePermissions(stringValue string) (uint32, error) {
+	mode, err := strconv.ParseUint(stringValue, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse file permissions, must be an octal value (e.g. 0640): %s", stringValue)
+	}
+
+	if mode > 0777 {
+		return 0, fmt.Errorf("invalid file permissions, must be between 0000 and 0777: %s", stringValue)
+	}
+
+	return uint32(mode), nil
+}
+
+// ParseDotenv parses KEY=VALUE lines, ignoring comments. single quoted values are unquoted like shell words (the generated dotenv templates write
+// them that way) and double quoted values are unescaped like json strings. both may span multiple lines, an unterminated quote is an error
+func ParseDotenv(content []byte) (map[string][]byte, error) {
+	values := map[string][]byte{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	// pem keys and certificates are longer than the default token size when written on one line
+	scanner.Buffer(nil, 1024*1024)
+	// bufio.ScanLines drops a carriage return before the line break, which is part of a quoted value
+	scanner.Split(scanLinesKeepingCarriageReturns)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !found {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, "'"):
+			// the line breaks and trailing spaces of a single quoted value are part of it, so it continues with the untrimmed lines
+			_, value, _ = strings.Cut(scanner.Text(), "=")
+			value = strings.TrimLeft(value, " \t")
+
+			unquoted, closed := unquoteShellValue(value)
+			for !closed && scanner.Scan() {
+				value += "\n" + scanner.Text()
+				unquoted, closed = unquoteShellValue(value)
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated single quoted value of %s", key)
+			}
+			values[key] = []byte(unquoted)
+		case strings.HasPrefix(value, "\""):
+			// a double quoted value continues until a line ends with an unescaped quote
+			for !isClosedDoubleQuote(value) && scanner.Scan() {
+				value += "\n" + strings.TrimRight(scanner.Text(), " \t\r")
+			}
+			if !isClosedDoubleQuote(value) {
+				return nil, fmt.Errorf("unterminated double quoted value of %s", key)
+			}
+			values[key] = []byte(unquoteDotenvValue(value))
+		default:
+			values[key] = []byte(value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dotenv: %w", err)
+	}
+
+	return values, nil
+}
+
+// scanLinesKeepingCarriageReturns splits lines like bufio.ScanLines, but keeps a carriage return at the end of the line
+func scanLinesKeepingCarriageReturns(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// unquoteShellValue unquotes value like a shell word made of single quoted parts and backslash escaped characters, and reports whether
+// every quote was closed. the word ends at the first unquoted whitespace, so a trailing comment is ignored
+func unquoteShellValue(value string) (string, bool) {
+	var unquoted strings.Builder
+	quoted := false
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quoted:
+			if c == '\'' {
+				quoted = false
+			} else {
+				unquoted.WriteByte(c)
+			}
+		case c == '\'':
+			quoted = true
+		case c == '\\' && i+1 < len(value):
+			i++
+			unquoted.WriteByte(value[i])
+		case c == ' ' || c == '\t' || c == '\r':
+			return unquoted.String(), true
+		default:
+			unquoted.WriteByte(c)
+		}
+	}
+
+	return unquoted.String(), !quoted
+}
+
+// isClosedDoubleQuote reports whether value is a complete double quoted string, ending with a quote that isn't escaped
+func isClosedDoubleQuote(value string) bool {
+	if len(value) < 2 || value[len(value)-1] != '"' {
+		return false
+	}
+
+	backslashes := 0
+	for i := len(value) - 2; i > 0 && value[i] == '\\'; i-- {
+		backslashes++
+	}
+
+	return backslashes%2 == 0
+}
+
+// unquoteDotenvValue unescapes a double quoted value. values that aren't valid json strings (e.g. "C:\path" written by hand) only have their quotes removed
+func unquoteDotenvValue(value string) string {
+	var unquoted string
+	if err := json.Unmarshal([]byte(strings.ReplaceAll(value, "\n", "\\n")), &unquoted); err == nil {
+		return unquoted
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(value, "\""), "\"")
+}
+
+// GenerateTemplateContent returns an agent template that renders every secret selected by secrets in the format.
+// keys and values are written as json strings in json and yaml (a json string is a valid yaml double quoted scalar). dotenv values are
+// single quoted like shell words, so the file can be sourced by a shell as well as read by ParseDotenv.
+// the generated templates range over ints, so they require an agent built with go 1.22 or later
+func GenerateTemplateContent(format string, secrets *TemplateSecrets) (string, error) {
+	if secrets == nil || secrets.ProjectID == "" || secrets.Environment == "" {
+		return "", fmt.Errorf("secrets.project-id and secrets.environment are required for template format %s", format)
+	}
+
+	secretsPath := secrets.Path
+	if secretsPath == "" {
+		secretsPath = DefaultTemplateSecretsPath
+	}
+
+	// quoted, so the selector can't break out of the template action
+	listSecrets := fmt.Sprintf("listSecrets %s %s %s", strconv.Quote(secrets.ProjectID), strconv.Quote(secrets.Environment), strconv.Quote(secretsPath))
+
+	switch format {
+	case TemplateFormatDotenv:
+		return "{{ range $secret := " + listSecrets + " }}{{ $secret.Key }}=" + shellStringTemplate("$secret.Value") + "\n{{ end }}", nil
+	case TemplateFormatJSON:
+		return "{\n" +
+			"{{- range $index, $secret := " + listSecrets + " }}{{ if $index }},{{ end }}\n" +
+			"  " + jsonStringTemplate("$secret.Key") + ": " + jsonStringTemplate("$secret.Value") +
+			"{{- end }}\n}\n", nil
+	case TemplateFormatYAML:
+		return "{{ range $secret := " + listSecrets + " }}" + jsonStringTemplate("$secret.Key") + ": " + jsonStringTemplate("$secret.Value") + "\n{{ end }}", nil
+	}
+
+	return "", fmt.Errorf("template format %s not supported. please use %s", format, strings.Join(SupportedTemplateFormats, ", "))
+}
+
+// jsonStringTemplate returns a template snippet that renders the string field as a double quoted json string. the agent templates only have the
+// text/template builtins, which can't escape json: printf %q writes go escapes such as \a or \x7f and js escapes ' as \'. so the snippet walks the
+// bytes of the string (range over an int, go 1.22+), escapes quotes, backslashes and c0, del and c1 control characters (which yaml doesn't allow
+// unescaped) as \uXXXX, and prints every other rune as is from its utf-8 lead byte with %.1s, which truncates by rune
+func jsonStringTemplate(field string) string {
+	rest := "(slice " + field + " $i)"
+
+	return `"{{ range $i := len ` + field + ` }}{{ $c := index ` + field + ` $i }}` +
+		`{{ if eq $c 10 }}\n` +
+		`{{ else if or (eq $c 34) (eq $c 92) }}\{{ printf "%c" $c }}` +
+		`{{ else if or (lt $c 32) (eq $c 127) }}{{ printf "\\u%04x" $c }}` +
+		`{{ else if and (eq $c 194) (gt (len ` + rest + `) 1) (lt (index ` + rest + ` 1) 160) }}{{ printf "\\u%04x" (index ` + rest + ` 1) }}` +
+		`{{ else if or (lt $c 128) (ge $c 192) }}{{ printf "%.1s" ` + rest + ` }}` +
+		`{{ end }}{{ end }}"`
+}
+
+// shellStringTemplate returns a template snippet that renders the string field single quoted like a shell word. a quote closes the quoted
+// part, is written escaped and opens the next one. every other rune, line breaks included, is printed as is from its utf-8 lead byte with
+// %.1s, like in jsonStringTemplate (go 1.22+)
+func shellStringTemplate(field string) string {
+	return `'{{ range $i := len ` + field + ` }}{{ $c := index ` + field + ` $i }}` +
+		`{{ if eq $c 39 }}'\''` +
+		`{{ else if or (lt $c 128) (ge $c 192) }}{{ printf "%.1s" (slice ` + field + ` $i) }}` +
+		`{{ end }}{{ end }}'`
+}
@@ -13,11 +13,76 @@ type Template struct {
 	DestinationPath       string `yaml:"destination-path"`
 	TemplateContent       string `yaml:"template-content"`
 
+	// File ownership and permissions of the rendered destination file. Not supported on windows pods.
+	FilePermissions string `yaml:"file-permissions,omitempty"` // Octal file mode, e.g. "0640"
+	Owner           *int64 `yaml:"owner,omitempty"`            // UID that should own the rendered file
+	Group           *int64 `yaml:"group,omitempty"`            // GID that should own the rendered file, also used as the pod fsGroup if none is set
+
+	// Render every secret of a folder in a standard format instead of a user-authored template. Converted to base64-template-content when building the agent config.
+	Format  string           `yaml:"format,omitempty"` // dotenv, json or yaml
+	Secrets *TemplateSecrets `yaml:"secrets,omitempty"`
+
+	// Load the rendered template as dotenv into the environment of the app containers, by wrapping their entrypoint
+	Env bool `yaml:"env,omitempty"`
+
+	// Also write the rendered template to a kubernetes secret. Handled by the secret sync container, not passed to the agent.
+	KubernetesSecret *TemplateKubernetesSecret `yaml:"kubernetes-secret,omitempty"`
+
+	// Action to run when the template is re-rendered. Only used by the sidecar agent, converted to config.execute when building the agent config.
+	OnChange *TemplateOnChange `yaml:"on-change,omitempty"`
+
 	Config struct { // Configurations for the template
-		PollingInterval string `yaml:"polling-interval"` // How often to poll for changes in the secret
+		PollingInterval string                 `yaml:"polling-interval"`  // How often to poll for changes in the secret
+		Execute         *TemplateExecuteConfig `yaml:"execute,omitempty"` // Command the agent runs after the template is re-rendered
 	} `yaml:"config"`
 }
 
+// TemplateSecrets selects the secrets rendered by a template with a format
+type TemplateSecrets struct {
+	ProjectID   string `yaml:"project-id"`
+	Environment string `yaml:"environment"`    // Environment slug, e.g. dev
+	Path        string `yaml:"path,omitempty"` // Secret folder, defaults to /
+}
+
+type TemplateKubernetesSecret struct {
+	Name   string `yaml:"name"`             // Name of the secret in the pod namespace
+	Key    string `yaml:"key,omitempty"`    // Key in the secret, defaults to the file name of the destination path. Not used for dotenv
+	Format string `yaml:"format,omitempty"` // file (default) or dotenv. dotenv writes every KEY=VALUE line as a separate key, for use with envFrom
+	Only   bool   `yaml:"only,omitempty"`   // Only write to the secret, the rendered file is not mounted into the app containers
+}
+
+// SecretSyncTarget is passed to the secret sync container, one per template written to a kubernetes secret
+type SecretSyncTarget struct {
+	SecretName string `json:"secretName"`
+	Key        string `json:"key,omitempty"`
+	Format     string `json:"format"`
+	Path       string `json:"path"`
+}
+
+type TemplateExecuteConfig struct {
+	Command string `yaml:"command"`
+	Timeout int64  `yaml:"timeout"` // In seconds
+}
+
+// Exactly one of command, signal or http must be set
+type TemplateOnChange struct {
+	Command string                  `yaml:"command,omitempty"` // Shell command executed inside the agent container
+	Signal  *TemplateOnChangeSignal `yaml:"signal,omitempty"`
+	HTTP    *TemplateOnChangeHTTP   `yaml:"http,omitempty"`
+	Timeout int64                   `yaml:"timeout,omitempty"` // In seconds, defaults to 30
+}
+
+// The pod shares its process namespace, so the signal is sent to every process with the name, in any container of the pod
+type TemplateOnChangeSignal struct {
+	Signal  string `yaml:"signal"`  // e.g. SIGHUP
+	Process string `yaml:"process"` // Executable name of the process to signal
+}
+
+type TemplateOnChangeHTTP struct {
+	URL    string `yaml:"url"`
+	Method string `yaml:"method,omitempty"` // GET or POST, defaults to POST
+}
+
 type AuthConfig struct {
 	Type string `yaml:"type"`
 }
@@ -59,17 +124,76 @@ type ConfigMap struct {
 	Infisical struct {
 		Address                     string `yaml:"address"`
 		RevokeCredentialsOnShutdown bool   `yaml:"revoke-credentials-on-shutdown"`
+		InitTimeout                 string `yaml:"init-timeout,omitempty"` // How long the init agent may run, e.g. "5m" or "300"
 		Auth                        struct {
 			Type   string                 `yaml:"type"` // Supported types: kubernetes, ldap-auth, aws-iam
 			Config map[string]interface{} `yaml:"config"`
 		} `yaml:"auth"`
 		RetryConfig *RetryConfig `yaml:"retry-strategy,omitempty"`
+		TLS         *TLSConfig   `yaml:"tls,omitempty"`
+		Proxy       *ProxyConfig `yaml:"proxy,omitempty"`
 	} `yaml:"infisical"`
-	Templates []Template  `yaml:"templates"`
-	Cache     CacheConfig `yaml:"cache,omitempty"`
+	Templates        []Template                 `yaml:"templates"`
+	Cache            CacheConfig                `yaml:"cache,omitempty"`
+	ResourceProfiles map[string]ResourceProfile `yaml:"resource-profiles,omitempty"` // Take precedence over the profiles configured on the injector
+
+	Hash     string   `yaml:"-"` // Hash of the raw config, used to detect config changes on running pods
+	Source   string   `yaml:"-"` // Where the config was resolved from, e.g. "configmap/<name>"
+	Warnings []string `yaml:"-"` // Found while resolving the config, returned as admission warnings
 }
 
 type StartupScriptTemplateData struct {
-	ExitAfterAuth  bool
-	TimeoutSeconds int
+	ExitAfterAuth          bool
+	TimeoutSeconds         int
+	LogDir                 string // The agent output is written here, so failures can be summarized in the termination message
+	TerminationMessagePath string
+	CABundleDir            string // Set if a CA bundle is configured, the certificates in it are trusted by the agent
+	FileModes              []FileModeCommand
+}
+
+// FileModeCommand applies the file permissions and ownership of a template once the agent has rendered it
+type FileModeCommand struct {
+	Path    string // Shell quoted destination path
+	Command string
+}
+
+// TLSConfig is mounted into the agent containers only, so the agent can reach an infisical instance behind an internal CA
+type TLSConfig struct {
+	CABundle *TLSCABundle `yaml:"ca-bundle,omitempty"`
+
+	// The agent has no client certificate support. Kept so configs that set it are rejected instead of silently ignored
+	ClientCertificate interface{} `yaml:"client-certificate,omitempty"`
+}
+
+type TLSCABundle struct {
+	ConfigMap string `yaml:"config-map,omitempty"` // One of config-map or secret, in the namespace of the pod
+	Secret    string `yaml:"secret,omitempty"`
+	Key       string `yaml:"key,omitempty"` // Defaults to ca.crt
+}
+
+// ProxyConfig is set as env vars on the agent containers. each field overrides the proxy configured on the injector
+type ProxyConfig struct {
+	HTTPProxy      string `yaml:"http-proxy,omitempty"`
+	HTTPSProxy     string `yaml:"https-proxy,omitempty"`
+	NoProxy        string `yaml:"no-proxy,omitempty"`
+	InheritFromApp bool   `yaml:"inherit-from-app,omitempty"` // Use the proxy env vars of the primary app container
+}
+
+// ResourceProfile is a named set of resources for the injected containers, selected with the resource profile annotation
+type ResourceProfile struct {
+	Requests ResourceValues   `yaml:"requests,omitempty" json:"requests,omitempty"`
+	Limits   *ResourceValues  `yaml:"limits,omitempty" json:"limits,omitempty"`   // No limits are set if omitted, e.g. for clusters that use LimitRanges
+	Windows  *ResourceProfile `yaml:"windows,omitempty" json:"windows,omitempty"` // Used instead of the profile for windows pods
+}
+
+type ResourceValues struct {
+	CPU              string `yaml:"cpu,omitempty" json:"cpu,omitempty"`
+	Memory           string `yaml:"memory,omitempty" json:"memory,omitempty"`
+	EphemeralStorage string `yaml:"ephemeral-storage,omitempty" json:"ephemeral-storage,omitempty"`
+}
+
+// PodPlatform is the os and possible cpu architectures of the nodes a pod can be scheduled on
+type PodPlatform struct {
+	OS            string
+	Architectures []string // Empty if the pod doesn't constrain the architecture
 }
@@ -55,3 +55,28 @@ func Dir(path string, isWindows bool) string {
 
 	return path[:lastSep]
 }
+
+func Base(path string, isWindows bool) string {
+	var sep string
+	if isWindows {
+		sep = "\\"
+	} else {
+		sep = "/"
+	}
+
+	if path == "" {
+		return "."
+	}
+
+	path = strings.TrimRight(path, sep)
+
+	if path == "" {
+		return sep
+	}
+
+	if lastSep := strings.LastIndex(path, sep); lastSep != -1 {
+		path = path[lastSep+1:]
+	}
+
+	return path
+}
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// the settings configured on the injector deployment as JSON env vars. they're parsed once at startup by LoadSettings,
+// so an invalid value fails the deployment instead of every pod admission
+var (
+	configuredAgentImageMap    map[string]string
+	configuredImagePullSecrets map[string][]string
+	configuredResourceProfiles map[string]ResourceProfile
+	configuredAgentProxy       ProxyConfig
+	configuredImagePullPolicy  corev1.PullPolicy
+	configuredInjectorImage    string
+)
+
+// LoadSettings parses and validates the settings env vars of the injector
+func LoadSettings() error {
+	configuredInjectorImage = os.Getenv(EnvInjectorImage)
+
+	if value := os.Getenv(EnvAgentImageMap); value != "" {
+		if err := json.Unmarshal([]byte(value), &configuredAgentImageMap); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", EnvAgentImageMap, err)
+		}
+
+		for platform, image := range configuredAgentImageMap {
+			if platformOS, arch, found := strings.Cut(platform, "/"); !found || platformOS == "" || arch == "" {
+				return fmt.Errorf("invalid platform %s in %s, must be os/arch (e.g. linux/arm64)", platform, EnvAgentImageMap)
+			}
+			if image == "" {
+				return fmt.Errorf("empty image for platform %s in %s", platform, EnvAgentImageMap)
+			}
+		}
+	}
+
+	if value := os.Getenv(EnvAgentImagePullSecrets); value != "" {
+		if err := json.Unmarshal([]byte(value), &configuredImagePullSecrets); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", EnvAgentImagePullSecrets, err)
+		}
+
+		for registry, secrets := range configuredImagePullSecrets {
+			for _, secret := range secrets {
+				if errs := validation.IsDNS1123Subdomain(secret); len(errs) > 0 {
+					return fmt.Errorf("invalid pull secret name %q for registry %s in %s: %s", secret, registry, EnvAgentImagePullSecrets, strings.Join(errs, ", "))
+				}
+			}
+		}
+	}
+
+	if value := os.Getenv(EnvAgentResourceProfiles); value != "" {
+		if err := json.Unmarshal([]byte(value), &configuredResourceProfiles); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", EnvAgentResourceProfiles, err)
+		}
+
+		for name, profile := range configuredResourceProfiles {
+			if err := profile.Validate(); err != nil {
+				return fmt.Errorf("invalid resource profile %s in %s: %w", name, EnvAgentResourceProfiles, err)
+			}
+		}
+	}
+
+	configuredAgentProxy = ProxyConfig{
+		HTTPProxy:  os.Getenv(EnvAgentHTTPProxy),
+		HTTPSProxy: os.Getenv(EnvAgentHTTPSProxy),
+		NoProxy:    os.Getenv(EnvAgentNoProxy),
+	}
+
+	configuredImagePullPolicy = corev1.PullPolicy(os.Getenv(EnvAgentImagePullPolicy))
+	switch configuredImagePullPolicy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		return fmt.Errorf("invalid %s %s. please use %s, %s or %s", EnvAgentImagePullPolicy, configuredImagePullPolicy, corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever)
+	}
+
+	return nil
+}
+
+// Validate checks that the quantities of the profile can be parsed
+func (p ResourceProfile) Validate() error {
+	if _, err := p.Requests.ResourceList(); err != nil {
+		return fmt.Errorf("invalid requests: %w", err)
+	}
+
+	if p.Limits != nil {
+		if _, err := p.Limits.ResourceList(); err != nil {
+			return fmt.Errorf("invalid limits: %w", err)
+		}
+	}
+
+	if p.Windows != nil {
+		if err := p.Windows.Validate(); err != nil {
+			return fmt.Errorf("windows: %w", err)
+		}
+	}
+
+	return nil
+}
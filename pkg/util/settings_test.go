@@ -0,0 +1,91 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestLoadSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantProxy  ProxyConfig
+		wantPolicy corev1.PullPolicy
+		wantImage  string
+		wantErr    string
+	}{
+		{
+			name: "nothing set",
+		},
+		{
+			name: "proxy",
+			env: map[string]string{
+				EnvAgentHTTPProxy:  "http://proxy:3128",
+				EnvAgentHTTPSProxy: "http://proxy:3129",
+				EnvAgentNoProxy:    ".svc,.cluster.local",
+			},
+			wantProxy: ProxyConfig{HTTPProxy: "http://proxy:3128", HTTPSProxy: "http://proxy:3129", NoProxy: ".svc,.cluster.local"},
+		},
+		{
+			name:      "injector image",
+			env:       map[string]string{EnvInjectorImage: "infisical/infisical-agent-injector:v0.1.12"},
+			wantImage: "infisical/infisical-agent-injector:v0.1.12",
+		},
+		{
+			name:       "pull policy",
+			env:        map[string]string{EnvAgentImagePullPolicy: "Always"},
+			wantPolicy: corev1.PullAlways,
+		},
+		{
+			name:    "invalid pull policy",
+			env:     map[string]string{EnvAgentImagePullPolicy: "Sometimes"},
+			wantErr: "invalid AGENT_IMAGE_PULL_POLICY Sometimes",
+		},
+		{
+			name:    "invalid image map",
+			env:     map[string]string{EnvAgentImageMap: `{"linux": "infisical/cli"}`},
+			wantErr: "invalid platform linux",
+		},
+		{
+			name:    "invalid pull secret",
+			env:     map[string]string{EnvAgentImagePullSecrets: `{"registry.example.com": ["Not_Valid"]}`},
+			wantErr: `invalid pull secret name "Not_Valid"`,
+		},
+		{
+			name:    "invalid resource profile",
+			env:     map[string]string{EnvAgentResourceProfiles: `{"tiny": {"requests": {"cpu": "lots"}}}`},
+			wantErr: "invalid resource profile tiny",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{EnvAgentImageMap, EnvAgentImagePullSecrets, EnvAgentResourceProfiles, EnvAgentImagePullPolicy, EnvAgentHTTPProxy, EnvAgentHTTPSProxy, EnvAgentNoProxy, EnvInjectorImage} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			err := LoadSettings()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := GetAgentProxy(); got != tt.wantProxy {
+				t.Errorf("got proxy %+v, want %+v", got, tt.wantProxy)
+			}
+			if got := GetInjectorImage(); got != tt.wantImage {
+				t.Errorf("got injector image %q, want %q", got, tt.wantImage)
+			}
+			if got := GetAgentImagePullPolicy(); got != tt.wantPolicy {
+				t.Errorf("got pull policy %q, want %q", got, tt.wantPolicy)
+			}
+		})
+	}
+}